@@ -0,0 +1,159 @@
+package mimeparse
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archive is an archive the MIME parts are written to, as entries, rather
+// than to separate files, see Options.Archive. The same archive can receive
+// the parts of several messages. It must be closed once they are all parsed.
+type Archive struct {
+	zip  *zip.Writer
+	tar  *tar.Writer
+	gzip *gzip.Writer
+
+	// The entries are written one at a time, whatever Options.Workers
+	mu sync.Mutex
+}
+
+// NewZipArchive returns an Archive writing a zip archive to w.
+func NewZipArchive(w io.Writer) *Archive {
+	return &Archive{zip: zip.NewWriter(w)}
+}
+
+// NewTarArchive returns an Archive writing a tar archive to w, compressed
+// with gzip if compress is true.
+func NewTarArchive(w io.Writer, compress bool) *Archive {
+
+	a := &Archive{}
+	if compress {
+		a.gzip = gzip.NewWriter(w)
+		w = a.gzip
+	}
+	a.tar = tar.NewWriter(w)
+
+	return a
+
+}
+
+// Close completes the archive, writing the central directory of a zip
+// archive, or the trailer of a tar archive. It doesn't close the underlying
+// writer.
+func (a *Archive) Close() error {
+
+	var err error
+	if a.zip != nil {
+		err = a.zip.Close()
+	} else {
+		err = a.tar.Close()
+		if a.gzip != nil {
+			if e := a.gzip.Close(); err == nil {
+				err = e
+			}
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("closing archive: %w", err)
+	}
+
+	return nil
+
+}
+
+// writeEntry writes the data read from decoder to the entry name of the
+// archive, with the permissions perm and the modification time date, failing
+// with ErrTooLarge if there are more than limit bytes, unless limit is
+// negative. Unlike a file, an entry can't be removed once created: on error,
+// the entry is left with the data written so far.
+func (a *Archive) writeEntry(name string, decoder *stepReader, limit int64, perm os.FileMode, date time.Time) (int64, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.tar != nil {
+		return a.writeTarEntry(name, decoder, limit, perm, date)
+	}
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: date}
+	header.SetMode(perm)
+
+	w, err := a.zip.CreateHeader(header)
+	if err != nil {
+		return 0, &WriteError{Path: name, Err: err}
+	}
+
+	return copyPart(w, decoder, name, limit)
+
+}
+
+// writeTarEntry does the job of writeEntry for a tar archive. As the header
+// of an entry holds its size, the decoded data is first written to a
+// temporary file, so whatever the size of the part, only a small buffer is
+// held in memory. Nothing is written to the archive on error.
+func (a *Archive) writeTarEntry(name string, decoder *stepReader, limit int64, perm os.FileMode, date time.Time) (int64, error) {
+
+	temp, err := os.CreateTemp("", "mimeparse-*")
+	if err != nil {
+		return 0, &WriteError{Path: name, Err: err}
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	size, err := copyPart(temp, decoder, name, limit)
+	if err != nil {
+		return size, err
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return 0, &WriteError{Path: name, Err: err}
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(perm.Perm()),
+		ModTime:  date,
+	}
+	if err := a.tar.WriteHeader(header); err != nil {
+		return 0, &WriteError{Path: name, Err: err}
+	}
+
+	written, err := io.Copy(a.tar, temp)
+	if err != nil {
+		return written, &WriteError{Path: name, Err: err}
+	}
+
+	return written, nil
+
+}
+
+// entryName returns the name of the entry of the archive where the file
+// filename is written. Options.OutputDir is the directory of the entries
+// within the archive, so the messages of a mailbox are kept apart as they
+// are on disk.
+func (opts Options) entryName(filename string) string {
+	return strings.TrimLeft(path.Join(filepath.ToSlash(opts.OutputDir), filename), "/")
+}
+
+// entryDate returns the modification time of the entries written for a
+// message dated date, which may be unknown.
+func entryDate(date time.Time) time.Time {
+
+	if date.IsZero() {
+		return time.Now()
+	}
+
+	return date
+
+}
@@ -0,0 +1,115 @@
+package mimeparse
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archiveMessage is a message with a part which can't be decoded, between
+// two parts which can.
+const archiveMessage = "From: alice@example.com\r\nDate: Mon, 2 Jan 2023 10:00:00 +0100\r\n" +
+	"Content-Type: multipart/mixed; boundary=XX\r\n\r\n" +
+	"--XX\r\nContent-Type: text/plain\r\n\r\nHello\r\n" +
+	"--XX\r\nContent-Type: application/octet-stream\r\nContent-Transfer-Encoding: x-unknown\r\n\r\n???\r\n" +
+	"--XX\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=a.pdf\r\n" +
+	"Content-Transfer-Encoding: base64\r\n\r\nJVBERi0=\r\n" +
+	"--XX--\r\n"
+
+// The parts are written as the entries of a zip archive, in the directory
+// OutputDir, the archive being complete even when a part can't be written.
+func TestZipArchive(t *testing.T) {
+
+	var b bytes.Buffer
+	archive := NewZipArchive(&b)
+	_, err := ParseEmail(strings.NewReader(archiveMessage), Options{Archive: archive, OutputDir: "messages/1", Verbosity: Quiet})
+	if err == nil {
+		t.Error("got no error, want the one of the second part")
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("closing archive: %v", err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	entries := make(map[string]string)
+	for _, file := range r.File {
+		f, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", file.Name, err)
+		}
+		entries[file.Name] = string(data)
+		if file.Modified.Year() != 2023 {
+			t.Errorf("%s: got modification time %v, want the date of the message", file.Name, file.Modified)
+		}
+	}
+
+	want := map[string]string{"messages/1/XX-1.txt": "Hello", "messages/1/a.pdf": "%PDF-"}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got entries %q, want %q", entries, want)
+	}
+
+}
+
+// The parts are written as the entries of a tar archive, compressed or not,
+// with their size, permissions and the date of the message.
+func TestTarArchive(t *testing.T) {
+
+	for _, compress := range []bool{false, true} {
+
+		var b bytes.Buffer
+		archive := NewTarArchive(&b, compress)
+		_, err := ParseEmail(strings.NewReader(archiveMessage), Options{Archive: archive, FileMode: 0o640, Verbosity: Quiet})
+		if err == nil {
+			t.Error("got no error, want the one of the second part")
+		}
+		if err := archive.Close(); err != nil {
+			t.Fatalf("closing archive: %v", err)
+		}
+
+		var r io.Reader = &b
+		if compress {
+			if r, err = gzip.NewReader(r); err != nil {
+				t.Fatalf("reading gzip: %v", err)
+			}
+		}
+		tr := tar.NewReader(r)
+		var names []string
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading archive: %v", err)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, header.Name)
+			if header.Size != int64(len(data)) || header.Mode != 0o640 || !header.ModTime.Equal(time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)) {
+				t.Errorf("%s: got size %d, mode %o, date %v", header.Name, header.Size, header.Mode, header.ModTime)
+			}
+		}
+
+		if want := []string{"XX-1.txt", "a.pdf"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("compress %v: got entries %q, want %q", compress, names, want)
+		}
+
+	}
+
+}
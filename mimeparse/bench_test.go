@@ -0,0 +1,162 @@
+package mimeparse
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// attachmentsMessage returns a multipart/mixed message with count base64
+// attachments of size bytes of random data each.
+func attachmentsMessage(count, size int) []byte {
+
+	var b bytes.Buffer
+	b.WriteString("From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n")
+
+	random := rand.New(rand.NewSource(1))
+	data := make([]byte, size)
+	for i := 1; i <= count; i++ {
+		random.Read(data)
+		encoded := base64.StdEncoding.EncodeToString(data)
+		fmt.Fprintf(&b, "--XX\r\nContent-Type: application/octet-stream\r\n")
+		fmt.Fprintf(&b, "Content-Disposition: attachment; filename=\"file-%d.bin\"\r\nContent-Transfer-Encoding: base64\r\n\r\n", i)
+		for len(encoded) > 76 {
+			b.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		b.WriteString(encoded + "\r\n")
+	}
+	b.WriteString("--XX--\r\n")
+
+	return b.Bytes()
+
+}
+
+// The throughput of the extraction of a message with many attachments, one
+// part at a time and with Options.Workers, the files being written to disk.
+func BenchmarkParseWorkers(b *testing.B) {
+
+	message := attachmentsMessage(16, 1<<20)
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+
+			opts := Options{OutputDir: b.TempDir(), Workers: workers, Verbosity: Quiet}
+			b.SetBytes(int64(len(message)))
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseEmail(bytes.NewReader(message), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+		})
+	}
+
+}
+
+// The parts are named in the order they are read, whatever the workers.
+func TestParseWorkers(t *testing.T) {
+
+	message := attachmentsMessage(8, 1024)
+
+	m, err := ParseEmail(bytes.NewReader(message), Options{DryRun: true, Workers: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Parts) != 8 {
+		t.Fatalf("got %d parts, want 8", len(m.Parts))
+	}
+	for i, part := range m.Parts {
+		if want := fmt.Sprintf("file-%d.bin", i+1); part.Filename != want || part.Written != 1024 {
+			t.Errorf("part %d: got %q of %d bytes, want %q of 1024 bytes", i+1, part.Filename, part.Written, want)
+		}
+	}
+
+}
+
+// With FailFast, the failure of a part in a worker stops the reading of the
+// next parts, rather than going unnoticed by the parsing, and abandons the part
+// being read. The message stalls in the middle of the part after the failing
+// one, so the worker of the failing part is done while it is being read.
+func TestParseWorkersFailFast(t *testing.T) {
+
+	message := attachmentsMessage(1000, 16)
+	failing := "--XX\r\nContent-Type: image/png\r\nContent-Transfer-Encoding: x-unknown\r\n\r\n???\r\n"
+	message = bytes.Replace(message, []byte("\r\n\r\n"), []byte("\r\n\r\n"+failing), 1)
+	stall := bytes.Index(message, []byte(failing)) + len(failing)
+	stall += bytes.Index(message[stall:], []byte("\r\n\r\n")) + 10
+
+	stop := make(chan struct{})
+	timer := time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	defer timer.Stop()
+	r := io.MultiReader(stalledReader{bytes.NewReader(message[:stall]), stop}, bytes.NewReader(message[stall:]))
+
+	m, err := ParseEmail(r, Options{DryRun: true, Workers: 2, FailFast: true})
+	var decode_error *DecodeError
+	if !errors.As(err, &decode_error) {
+		t.Errorf("got error %v, want the DecodeError of the first part", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want the second part abandoned", err)
+	}
+	if len(m.Parts) != 2 {
+		t.Fatalf("got %d parts, want the failing part, and the one read while it failed", len(m.Parts))
+	}
+	for i, part := range m.Parts {
+		if len(part.Error) == 0 {
+			t.Errorf("part %d: got no error", i+1)
+		}
+	}
+
+}
+
+// The extraction of a message with a single large attachment, written to
+// disk, which has to use the same memory whatever the size of the part.
+func BenchmarkParseLargeAttachment(b *testing.B) {
+
+	message := attachmentsMessage(1, 16<<20)
+	opts := Options{OutputDir: b.TempDir(), Verbosity: Quiet}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(message)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseEmail(bytes.NewReader(message), opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+}
+
+// largeAttachmentAllocs is the number of allocations allowed for the
+// extraction of a message with a single attachment, whatever its size, as
+// measured by TestParseLargeAttachmentAllocs: about 180 for the message, its
+// header and the file, none of them depending on the size of the part.
+const largeAttachmentAllocs = 200
+
+// The number of allocations doesn't grow with the size of the part.
+func TestParseLargeAttachmentAllocs(t *testing.T) {
+
+	dir := t.TempDir()
+	for _, size := range []int{64 << 10, 1 << 20, 4 << 20} {
+
+		message := attachmentsMessage(1, size)
+		allocs := testing.AllocsPerRun(3, func() {
+			if _, err := ParseEmail(bytes.NewReader(message), Options{OutputDir: dir, Verbosity: Quiet}); err != nil {
+				t.Fatal(err)
+			}
+		})
+		t.Logf("%d bytes: %v allocations", size, allocs)
+		if allocs > largeAttachmentAllocs {
+			t.Errorf("%d bytes: got %v allocations, want at most %d", size, allocs, largeAttachmentAllocs)
+		}
+
+	}
+
+}
@@ -0,0 +1,239 @@
+package mimeparse
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ErrNoTextBody is returned by TextBody for a message without a text/plain
+// body, such as a message only made of HTML.
+var ErrNoTextBody = errors.New("no text/plain body")
+
+// ErrNoHTMLBody is returned by HTMLBody for a message without a text/html body.
+var ErrNoHTMLBody = errors.New("no text/html body")
+
+// ErrTextBodyNotKept is returned by TextBody and HTMLBody for a message parsed
+// without Options.KeepTextBody, or with Options.DryRun.
+var ErrTextBodyNotKept = errors.New("text body not kept in memory")
+
+// TextBody returns the first text/plain body of the message, decoded from its
+// Content-Transfer-Encoding and converted to UTF-8 from its charset when the
+// charset is known. When the same content is provided into different formats,
+// as in a multipart/alternative, the text/plain part is preferred to the
+// others. ErrNoTextBody is returned if there is no text/plain body, or if it
+// was skipped, unless the message was parsed with Options.HTMLTextFallback and
+// has a text/html body, whose text is then returned, see HTMLText. The message must have been
+// parsed with Options.KeepTextBody, or ErrTextBodyNotKept is returned.
+func (m *Message) TextBody() (string, error) {
+
+	if !m.textBodyKept {
+		return "", ErrTextBodyNotKept
+	}
+
+	for _, part := range m.Parts {
+		if part.isTextBody() && !part.Skipped && part.ContentType == "text/plain" {
+			return part.text(), nil
+		}
+	}
+
+	if m.htmlTextFallback {
+		if html, err := m.HTMLBody(); err == nil {
+			return HTMLText(html), nil
+		}
+	}
+
+	return "", ErrNoTextBody
+
+}
+
+// blockElements are the HTML elements HTMLText puts on lines of their own.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "ol": true, "p": true,
+	"pre": true, "section": true, "table": true, "tr": true, "ul": true,
+}
+
+// hiddenElements are the HTML elements whose content HTMLText drops, as it
+// isn't text displayed.
+var hiddenElements = map[string]bool{
+	"head": true, "iframe": true, "noscript": true, "object": true,
+	"script": true, "style": true, "template": true, "title": true,
+}
+
+// HTMLText returns a plain text rendering of the HTML document, such as
+// for indexing: the tags are removed, along with the content of the elements
+// which aren't displayed, such as the scripts, the entities are decoded, and
+// the whitespaces are collapsed, the block elements, such as the paragraphs,
+// being put on lines of their own.
+func HTMLText(document string) string {
+
+	var lines []string
+	var line strings.Builder
+	endLine := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); len(text) > 0 {
+			lines = append(lines, text)
+		}
+		line.Reset()
+	}
+
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	hidden := ""
+	for {
+		kind := tokenizer.Next()
+		if kind == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch {
+		case len(hidden) > 0:
+			if kind == html.EndTagToken && token.Data == hidden {
+				hidden = ""
+			}
+		case kind == html.StartTagToken && hiddenElements[token.Data]:
+			hidden = token.Data
+		case kind == html.TextToken:
+			line.WriteString(token.Data)
+		case blockElements[token.Data]:
+			endLine()
+		case token.Data == "td" || token.Data == "th":
+			line.WriteString(" ")
+		}
+	}
+	endLine()
+
+	return strings.Join(lines, "\n")
+
+}
+
+// HTMLBody returns the text/html body of the message, decoded from its
+// Content-Transfer-Encoding and converted to UTF-8 from its charset when the
+// charset is known. As the alternatives of a multipart/alternative are given
+// in increasing order of preference, the last text/html part is the one
+// returned. ErrNoHTMLBody is returned if there is no text/html body, and
+// ErrTextBodyNotKept if the message wasn't parsed with Options.KeepTextBody.
+func (m *Message) HTMLBody() (string, error) {
+
+	if !m.textBodyKept {
+		return "", ErrTextBodyNotKept
+	}
+
+	for i := len(m.Parts) - 1; i >= 0; i-- {
+		if m.Parts[i].isTextBody() && !m.Parts[i].Skipped && m.Parts[i].ContentType == "text/html" {
+			return m.Parts[i].text(), nil
+		}
+	}
+
+	return "", ErrNoHTMLBody
+
+}
+
+// Attachment is an attachment of a message, with its decoded data.
+type Attachment struct {
+
+	// Filename is the name of the file the attachment is written to.
+	Filename string
+
+	// ContentType is the media type of the attachment, without its parameters.
+	ContentType string
+
+	// Data is the data of the attachment, decoded from its
+	// Content-Transfer-Encoding.
+	Data []byte
+}
+
+// ErrAttachmentsNotKept is returned by Attachments for a message parsed
+// without Options.KeepAttachments.
+var ErrAttachmentsNotKept = errors.New("attachments not kept in memory")
+
+// Attachments returns the attachments of the message, in the order they were
+// read, with their data. The inline parts, such as the body of the message,
+// aren't returned, nor the attachments skipped, or which couldn't be decoded.
+// The message must have been parsed with Options.KeepAttachments, or
+// ErrAttachmentsNotKept is returned.
+func (m *Message) Attachments() ([]Attachment, error) {
+
+	if !m.attachmentsKept {
+		return nil, ErrAttachmentsNotKept
+	}
+
+	var attachments []Attachment
+	for _, part := range m.Parts {
+		if part.isKeptAttachment() {
+			attachments = append(attachments, part.attachment())
+		}
+	}
+
+	return attachments, nil
+
+}
+
+// ErrAttachmentNotFound is returned, wrapped, by AttachmentByName when the
+// message has no such attachment.
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// AttachmentByName returns the attachment of the message, among the ones
+// returned by Attachments, named name by the message, see PartMeta.Name, once
+// made safe as the names given by the messages are, such as "invoice.pdf",
+// whatever the name of its file. The case of the names matters, unless the
+// message was parsed with Options.IgnoreNameCase. An error wrapping
+// ErrAttachmentNotFound is returned if there is no such attachment, and
+// ErrAttachmentsNotKept if the attachments weren't kept.
+func (m *Message) AttachmentByName(name string) (*Attachment, error) {
+
+	if !m.attachmentsKept {
+		return nil, ErrAttachmentsNotKept
+	}
+
+	filename := sanitizeFileName(name)
+	for _, part := range m.Parts {
+		if !part.isKeptAttachment() || len(part.Name) == 0 {
+			continue
+		}
+		if part.Name == filename || (m.ignoreNameCase && strings.EqualFold(part.Name, filename)) {
+			attachment := part.attachment()
+			return &attachment, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrAttachmentNotFound, name)
+
+}
+
+// isKeptAttachment reports whether the part is an attachment whose data was
+// kept, as returned by Attachments.
+func (part PartMeta) isKeptAttachment() bool {
+	return part.Attachment && !part.Skipped && len(part.SHA256) > 0
+}
+
+// attachment returns the Attachment of the part.
+func (part PartMeta) attachment() Attachment {
+	return Attachment{Filename: part.Filename, ContentType: part.ContentType, Data: part.content}
+}
+
+// isTextBody reports whether the part is a text part which makes the body
+// of the message, rather than an attachment.
+func (part PartMeta) isTextBody() bool {
+	return !part.Attachment && (part.ContentType == "text/plain" || part.ContentType == "text/html")
+}
+
+// text returns the decoded data of the part as an UTF-8 string, converted from
+// its charset if it is known, and if it wasn't already when written. The data
+// is returned as is otherwise.
+func (part PartMeta) text() string {
+
+	if part.converted {
+		return string(part.content)
+	}
+
+	if text, ok := decodeCharset(part.Charset, part.content); ok {
+		return text
+	}
+
+	return string(part.content)
+
+}
@@ -0,0 +1,286 @@
+package mimeparse
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// invoiceMessage is a message with several attachments, two of them named
+// alike by the message.
+const invoiceMessage = "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n" +
+	"--XX\r\nContent-Type: text/plain\r\n\r\nHere they are.\r\n" +
+	"--XX\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=\"invoice.pdf\"\r\n\r\nfirst invoice\r\n" +
+	"--XX\r\nContent-Type: text/csv\r\nContent-Disposition: attachment; filename=\"items.csv\"\r\n\r\na,b\r\n" +
+	"--XX\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=\"Invoice.PDF\"\r\n\r\nsecond invoice\r\n" +
+	"--XX--\r\n"
+
+func TestAttachmentByName(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		opts     Options
+		lookup   string
+		filename string
+		data     string
+	}{
+		{"plain", Options{}, "invoice.pdf", "invoice.pdf", "first invoice"},
+		{"case", Options{}, "Invoice.PDF", "Invoice (1).PDF", "second invoice"},
+		{"ignore case", Options{IgnoreNameCase: true}, "INVOICE.pdf", "invoice.pdf", "first invoice"},
+		{"positional names", Options{PositionalNames: true}, "invoice.pdf", "2-invoice.pdf", "first invoice"},
+		{"sender date names", Options{SenderDateNames: true}, "items.csv", "undated-alice-3.csv", "a,b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			test.opts.DryRun, test.opts.KeepAttachments = true, true
+			m, err := ParseEmail(strings.NewReader(invoiceMessage), test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			attachment, err := m.AttachmentByName(test.lookup)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if attachment.Filename != test.filename || string(attachment.Data) != test.data {
+				t.Errorf("got %q with %q, want %q with %q", attachment.Filename, attachment.Data, test.filename, test.data)
+			}
+
+		})
+	}
+
+	m, err := ParseEmail(strings.NewReader(invoiceMessage), Options{DryRun: true, KeepAttachments: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.AttachmentByName("receipt.pdf"); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("got error %v, want ErrAttachmentNotFound", err)
+	}
+
+	m, err = ParseEmail(strings.NewReader(invoiceMessage), Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.AttachmentByName("invoice.pdf"); !errors.Is(err, ErrAttachmentsNotKept) {
+		t.Errorf("got error %v, want ErrAttachmentsNotKept", err)
+	}
+
+}
+
+// The text of the body is only kept in memory when asked for, and never with
+// DryRun or for the parts skipped.
+func TestKeepTextBody(t *testing.T) {
+
+	tests := []struct {
+		name string
+		opts Options
+		text string
+		err  error
+	}{
+		{"kept", Options{KeepTextBody: true}, "Here they are.", nil},
+		{"not kept", Options{}, "", ErrTextBodyNotKept},
+		{"dry run", Options{KeepTextBody: true, DryRun: true}, "", ErrTextBodyNotKept},
+		{"skipped", Options{KeepTextBody: true, ExcludeTypes: []string{"text/plain"}}, "", ErrNoTextBody},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			test.opts.OutputDir, test.opts.Verbosity = t.TempDir(), Quiet
+			m, err := ParseEmail(strings.NewReader(invoiceMessage), test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			text, err := m.TextBody()
+			if text != test.text || !errors.Is(err, test.err) {
+				t.Errorf("got %q, %v, want %q, %v", text, err, test.text, test.err)
+			}
+			for _, part := range m.Parts {
+				if part.isTextBody() && len(part.content) > 0 && len(test.text) == 0 {
+					t.Errorf("%s: got %d bytes kept, want none", part.Filename, len(part.content))
+				}
+			}
+
+		})
+	}
+
+}
+
+// The attachments are returned with their decoded data, the inline parts and
+// the attachments skipped being left out.
+func TestAttachments(t *testing.T) {
+
+	parts, _, err := parseFixture(t, "base64.eml", Options{})
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("got %d parts, %v, want 2", len(parts), err)
+	}
+
+	m, err := ParseEmail(bytes.NewReader(readFixture(t, "base64.eml")), Options{DryRun: true, KeepAttachments: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	attachments, err := m.Attachments()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Attachment{{Filename: "pixel.png", ContentType: "image/png", Data: []byte(parts[1].data)}}
+	if !reflect.DeepEqual(attachments, want) {
+		t.Errorf("got %+v, want %+v", attachments, want)
+	}
+
+	m, err = ParseEmail(strings.NewReader(invoiceMessage), Options{DryRun: true, KeepAttachments: true, ExcludeTypes: []string{"text/*"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	attachments, err = m.Attachments()
+	if err != nil || len(attachments) != 2 || attachments[0].Filename != "invoice.pdf" || attachments[1].Filename != "Invoice (1).PDF" {
+		t.Errorf("got %+v, %v, want the two invoices", attachments, err)
+	}
+
+	m, err = ParseEmail(strings.NewReader(invoiceMessage), Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.Attachments(); !errors.Is(err, ErrAttachmentsNotKept) {
+		t.Errorf("got error %v, want ErrAttachmentsNotKept", err)
+	}
+
+}
+
+// The text parts without a charset are decoded in DefaultCharset, rather than
+// as US-ASCII.
+func TestDefaultCharset(t *testing.T) {
+
+	message := "From: alice@example.com\r\nContent-Type: text/plain\r\n\r\nCaf\xe9 cr\xe8me\r\n"
+	declared := "From: alice@example.com\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nCaf\xc3\xa9\r\n"
+
+	tests := []struct {
+		name    string
+		message string
+		opts    Options
+		charset string
+		text    string
+	}{
+		{"latin-1", message, Options{DefaultCharset: "iso-8859-1"}, "iso-8859-1", "Café crème\r\n"},
+		{"windows-1252", message, Options{DefaultCharset: "windows-1252"}, "windows-1252", "Café crème\r\n"},
+		{"none", message, Options{}, "", "Caf\xe9 cr\xe8me\r\n"},
+		{"declared", declared, Options{DefaultCharset: "iso-8859-1"}, "utf-8", "Café\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			opts := test.opts
+			opts.KeepTextBody, opts.OutputDir, opts.Verbosity = true, t.TempDir(), Quiet
+			m, err := ParseEmail(strings.NewReader(test.message), opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if m.Parts[0].Charset != test.charset {
+				t.Errorf("got charset %q, want %q", m.Parts[0].Charset, test.charset)
+			}
+			if text, err := m.TextBody(); err != nil || text != test.text {
+				t.Errorf("TextBody: got %q, %v, want %q", text, err, test.text)
+			}
+
+			// The file is converted to UTF-8 from the charset too
+			opts.ConvertCharset = true
+			parts, _, err := parseTest(t, strings.NewReader(test.message), opts)
+			if err != nil || parts[0].data != test.text {
+				t.Errorf("ConvertCharset: got %q, %v, want %q", parts, err, test.text)
+			}
+
+		})
+	}
+
+}
+
+// The last text/html part is the body preferred by the message.
+func TestHTMLBody(t *testing.T) {
+
+	message := "From: alice@example.com\r\nContent-Type: multipart/alternative; boundary=XX\r\n\r\n" +
+		"--XX\r\nContent-Type: text/plain\r\n\r\nHello\r\n" +
+		"--XX\r\nContent-Type: text/html; charset=iso-8859-1\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n<p>Caf=E9</p>\r\n" +
+		"--XX\r\nContent-Type: text/html\r\n\r\n<p>Hello</p>\r\n" +
+		"--XX--\r\n"
+
+	m, err := ParseEmail(strings.NewReader(message), Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.HTMLBody(); !errors.Is(err, ErrTextBodyNotKept) {
+		t.Errorf("got error %v, want ErrTextBodyNotKept", err)
+	}
+
+	m, err = ParseEmail(strings.NewReader(message), Options{OutputDir: t.TempDir(), KeepTextBody: true, Verbosity: Quiet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if html, err := m.HTMLBody(); err != nil || html != "<p>Hello</p>" {
+		t.Errorf("got %q, %v, want %q", html, err, "<p>Hello</p>")
+	}
+
+	// The HTML is decoded and converted from its charset
+	first, _, _ := strings.Cut(message, "--XX\r\nContent-Type: text/html\r\n")
+	m, err = ParseEmail(strings.NewReader(first+"--XX--\r\n"), Options{OutputDir: t.TempDir(), KeepTextBody: true, Verbosity: Quiet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if html, err := m.HTMLBody(); err != nil || html != "<p>Café</p>" {
+		t.Errorf("got %q, %v, want %q", html, err, "<p>Café</p>")
+	}
+
+	// The parts skipped aren't bodies
+	m, err = ParseEmail(strings.NewReader(message), Options{OutputDir: t.TempDir(), KeepTextBody: true, ExcludeTypes: []string{"text/html"}, Verbosity: Quiet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.HTMLBody(); !errors.Is(err, ErrNoHTMLBody) {
+		t.Errorf("got error %v, want ErrNoHTMLBody", err)
+	}
+
+}
+
+func TestHTMLText(t *testing.T) {
+
+	tests := []struct {
+		html string
+		want string
+	}{
+		{"<p>Hello <b>Bob</b>,</p><p>See   you&nbsp;soon &amp; bye.</p>", "Hello Bob,\nSee you soon & bye."},
+		{"<html><head><title>T</title><style>p { color: red }</style></head><body>Text<script>alert(1)</script></body></html>", "Text"},
+		{"<ul><li>one</li><li>two</li></ul>line<br>break", "one\ntwo\nline\nbreak"},
+		{"<table><tr><td>a</td><td>b</td></tr><tr><th>c</th></tr></table>", "a b\nc"},
+		{"plain text", "plain text"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := HTMLText(test.html); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.html, got, test.want)
+		}
+	}
+
+	// With HTMLTextFallback, TextBody returns the text of the HTML body of
+	// a message without a text/plain body
+	message := "From: alice@example.com\r\nContent-Type: text/html\r\n\r\n<p>Hello</p><p>Bob</p>\r\n"
+	for _, fallback := range []bool{false, true} {
+		m, err := ParseEmail(strings.NewReader(message), Options{OutputDir: t.TempDir(), KeepTextBody: true, HTMLTextFallback: fallback, Verbosity: Quiet})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		text, err := m.TextBody()
+		if fallback && (err != nil || text != "Hello\nBob") {
+			t.Errorf("got %q, %v, want %q", text, err, "Hello\nBob")
+		}
+		if !fallback && !errors.Is(err, ErrNoTextBody) {
+			t.Errorf("got %q, %v, want ErrNoTextBody", text, err)
+		}
+	}
+
+}
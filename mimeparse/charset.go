@@ -0,0 +1,254 @@
+package mimeparse
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
+)
+
+// lookupCharset returns the encoding for the charset name, as found in a
+// Content-Type or a RFC 2231 value. The IANA names are tried first, then the
+// labels known to web browsers, which also cover many of the aliases used by
+// mailers. Nil is returned for UTF-8 and its subset US-ASCII, as their data
+// doesn't need any conversion, and false for an unknown charset. The names
+// misspelled by some mailers, see charsetSpellings, are also handled.
+func lookupCharset(charset string) (encoding.Encoding, bool) {
+
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return nil, true
+	}
+
+	names := []string{charset}
+	for _, spelling := range charsetSpellings {
+		if name := spelling.pattern.ReplaceAllString(charset, spelling.name); name != charset {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		if enc, err := ianaindex.MIME.Encoding(name); err == nil && enc != nil {
+			return enc, true
+		}
+		if enc, err := htmlindex.Get(name); err == nil {
+			return enc, true
+		}
+	}
+
+	return nil, false
+
+}
+
+// charsetSpellings turn the misspelled charset names found in messages, such
+// as "iso8859_1", "8859-15", "latin9", "win-1252" or "cp-1252", into names
+// known to lookupCharset, in lower case.
+var charsetSpellings = []struct {
+	pattern *regexp.Regexp
+	name    string
+}{
+	{regexp.MustCompile(`^(iso)?[-_ ]?8859[-_ ]?([0-9]+)$`), "iso-8859-$2"},
+	{regexp.MustCompile(`^(iso[-_ ]?)?latin[-_ ]?([0-9]+)$`), "latin$2"},
+	{regexp.MustCompile(`^(iso[-_ ]?)?latin[-_ ]?([0-9]+)$`), "latin-$2"},
+	{regexp.MustCompile(`^(windows|win|cp)[-_ ]?(125[0-8])$`), "windows-$2"},
+	{regexp.MustCompile(`^cp[-_ ]?([0-9]+)$`), "ibm$1"},
+}
+
+// decodeCharset converts data from charset to a UTF-8 string. The boolean
+// returned is false if the charset isn't supported.
+func decodeCharset(charset string, data []byte) (string, bool) {
+
+	enc, ok := lookupCharset(charset)
+	if !ok {
+		return "", false
+	}
+	if enc == nil {
+		return string(data), utf8.Valid(data)
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", false
+	}
+
+	return string(decoded), true
+
+}
+
+// encodedWord matches a RFC 2047 encoded-word, capturing its charset, its
+// encoding and its encoded text.
+var encodedWord = regexp.MustCompile(`=\?([^?\s]+)\?([bBqQ])\?([^?\s]*)\?=`)
+
+// DecodeHeader decodes the RFC 2047 encoded-words of the header field value,
+// as mime.WordDecoder.DecodeHeader() does, but more robustly: the data of the
+// adjacent encoded-words in the same charset is decoded as a whole, so the
+// characters split over two words, as some mailers do with multi-byte
+// charsets, are decoded, and the base64 words without their padding are
+// decoded too. As RFC 2047 says, the whitespace between adjacent encoded-words
+// is removed. The encoded-words which can't be decoded, such as words in an
+// unknown charset, are kept as they are, and the errors met returned along
+// with the value decoded as far as possible.
+func DecodeHeader(value string) (string, error) {
+
+	matches := encodedWord.FindAllStringSubmatchIndex(value, -1)
+	if len(matches) == 0 {
+		return value, nil
+	}
+
+	var b strings.Builder
+	var errs []error
+
+	// The data of the current run of adjacent encoded-words in the same
+	// charset, with the start and end of the run in value
+	var run []byte
+	run_charset, run_start, run_end := "", -1, -1
+	flush := func() {
+		if run_start < 0 {
+			return
+		}
+		decoded, err := wordCharset(run_charset, run)
+		if err != nil {
+			errs = append(errs, err)
+			decoded = value[run_start:run_end]
+		}
+		b.WriteString(decoded)
+		run, run_start = nil, -1
+	}
+
+	last, word := 0, false
+	for _, match := range matches {
+
+		between := value[last:match[0]]
+		adjacent := word && len(strings.TrimSpace(between)) == 0
+		last = match[1]
+
+		// The language of RFC 2231 may follow the charset, such as "utf-8*fr"
+		charset, _, _ := strings.Cut(strings.ToLower(value[match[2]:match[3]]), "*")
+		data, err := decodeWordText(value[match[4]], value[match[6]:match[7]])
+		if err != nil {
+			flush()
+			errs = append(errs, fmt.Errorf("decoding %q: %w", value[match[0]:match[1]], err))
+			b.WriteString(between)
+			b.WriteString(value[match[0]:match[1]])
+			word = false
+			continue
+		}
+
+		if !adjacent || charset != run_charset {
+			flush()
+		}
+		if !adjacent {
+			b.WriteString(between)
+		}
+		if run_start < 0 {
+			run_charset, run_start = charset, match[0]
+		}
+		run = append(run, data...)
+		run_end = match[1]
+		word = true
+
+	}
+	flush()
+	b.WriteString(value[last:])
+
+	return b.String(), errors.Join(errs...)
+
+}
+
+// decodeWordText decodes the encoded text of a RFC 2047 encoded-word, whose
+// encoding is 'B', base64, with or without its padding, or 'Q', a variant
+// of quoted-printable, in either case.
+func decodeWordText(encoding byte, text string) ([]byte, error) {
+
+	if encoding == 'b' || encoding == 'B' {
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(text, "="))
+	}
+
+	data := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '_':
+			data = append(data, ' ')
+		case text[i] == '=':
+			if i+2 >= len(text) {
+				return nil, errors.New("truncated escape sequence")
+			}
+			b, err := hex.DecodeString(text[i+1 : i+3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid escape sequence %q", text[i:i+3])
+			}
+			data = append(data, b...)
+			i += 2
+		default:
+			data = append(data, text[i])
+		}
+	}
+
+	return data, nil
+
+}
+
+// wordCharset converts data, the decoded data of encoded-words, from charset
+// to UTF-8. The invalid UTF-8 sequences are kept as is, as by
+// mime.WordDecoder.
+func wordCharset(charset string, data []byte) (string, error) {
+
+	enc, ok := lookupCharset(charset)
+	if !ok {
+		return "", fmt.Errorf("unhandled charset %q", charset)
+	}
+	if enc == nil {
+		return string(data), nil
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("converting from charset %q: %w", charset, err)
+	}
+
+	return string(decoded), nil
+
+}
+
+// newWordDecoder returns a decoder for the RFC 2047 encoded-words, which
+// handles all the charsets known to lookupCharset.
+func newWordDecoder() *mime.WordDecoder {
+
+	return &mime.WordDecoder{
+		CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+			enc, ok := lookupCharset(charset)
+			if !ok {
+				return nil, fmt.Errorf("unhandled charset %q", charset)
+			}
+			if enc == nil {
+				return input, nil
+			}
+			return transform.NewReader(input, enc.NewDecoder()), nil
+		},
+	}
+
+}
+
+// charsetReader returns a reader converting the data read from r from charset
+// to UTF-8. R is returned as is if the charset is UTF-8 or is unknown.
+func charsetReader(charset string, r io.Reader) io.Reader {
+
+	enc, ok := lookupCharset(charset)
+	if !ok || enc == nil {
+		return r
+	}
+
+	return transform.NewReader(r, enc.NewDecoder())
+
+}
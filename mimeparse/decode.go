@@ -0,0 +1,254 @@
+package mimeparse
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/text/transform"
+)
+
+// base64Cleaner filters out the whitespaces found in base64 data read from r.
+// base64.NewDecoder() already ignores '\r' and '\n', but some mailers also
+// indent or pad the lines with spaces and tabs. The data is also turned into
+// standard base64, as expected by base64.StdEncoding, when it is wrongly sent
+// with the URL-safe alphabet, or without its padding. A byte order mark
+// prepended to the data by some mailers, such as the UTF-8 one, is dropped
+// too. When lenient, all the characters which aren't base64 are dropped, see
+// Options.LenientBase64.
+type base64Cleaner struct {
+	r       io.Reader
+	lenient bool
+
+	// Set when the data uses the URL-safe alphabet, or lacks its padding,
+	// and number of characters of the URL-safe alphabet turned into the ones
+	// of the standard alphabet
+	urlSafe, unpadded bool
+	remapped          int
+
+	// When lenient, the number of characters dropped, and whether some
+	// padding was found, as it is dropped too. The padding is only counted
+	// as dropped once base64 characters are found after it, in pad.
+	dropped int
+	padded  bool
+	pad     int
+
+	// Number of base64 characters read so far, and of padding characters
+	// still to be returned at the end of the data
+	count, padding int
+
+	// Number of bytes of the byte order mark dropped before the data
+	bom int
+}
+
+// bomBytes are the bytes of the UTF-8 and UTF-16 byte order marks, which are
+// all invalid in base64.
+var bomBytes = map[byte]bool{0xef: true, 0xbb: true, 0xbf: true, 0xfe: true, 0xff: true}
+
+func (c *base64Cleaner) Read(p []byte) (int, error) {
+
+	if c.padding > 0 {
+		n := copy(p, strings.Repeat("=", c.padding))
+		if c.padding -= n; c.padding > 0 {
+			return n, nil
+		}
+		return n, io.EOF
+	}
+
+	for {
+
+		n, err := c.r.Read(p)
+
+		// Remove the whitespaces in place, and switch from the URL-safe
+		// alphabet, whose characters aren't used by the standard one
+		kept := 0
+		for _, b := range p[:n] {
+			switch b {
+			case ' ', '\t', '\r', '\n', '\f', '\v':
+				continue
+			case '-':
+				b, c.urlSafe = '+', true
+				c.remapped++
+			case '_':
+				b, c.urlSafe = '/', true
+				c.remapped++
+			}
+			if c.count+kept == 0 && bomBytes[b] {
+				c.bom++
+				continue
+			}
+			if c.lenient && !isBase64(b) {
+				if b == '=' {
+					c.padded = true
+					c.pad++
+				} else {
+					c.dropped++
+				}
+				continue
+			}
+
+			// The padding found before the end of the data is dropped
+			if c.pad > 0 {
+				c.dropped += c.pad
+				c.padded, c.pad = false, 0
+			}
+			p[kept] = b
+			kept++
+		}
+		c.count += kept
+
+		// Complete the padding, if it is missing at the end of the data
+		if err == io.EOF && c.count%4 != 0 {
+			c.unpadded = !c.padded
+			c.padding = 4 - c.count%4
+			c.count += c.padding
+			m := copy(p[kept:], strings.Repeat("=", c.padding))
+			kept += m
+			if c.padding -= m; c.padding > 0 {
+				return kept, nil
+			}
+		}
+
+		// Don't return 0 bytes with no error if there was something to read,
+		// as it could be taken for the end of the data by some readers
+		if kept > 0 || err != nil || n == 0 {
+			return kept, err
+		}
+
+	}
+
+}
+
+// isBase64 reports whether b is a character of the standard base64 alphabet,
+// padding excluded.
+func isBase64(b byte) bool {
+	return (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '+' || b == '/'
+}
+
+// variant returns the name of the base64.Encoding the data read was actually
+// encoded with, or an empty string for base64.StdEncoding.
+func (c *base64Cleaner) variant() string {
+
+	switch {
+	case c.urlSafe && c.unpadded:
+		return "RawURLEncoding"
+	case c.urlSafe:
+		return "URLEncoding"
+	case c.unpadded:
+		return "RawStdEncoding"
+	}
+
+	return ""
+
+}
+
+// softBreakTrimmer drops the dangling soft line break ending the
+// quoted-printable data read from r, a '=' followed by nothing but spaces,
+// tabs or a CR, which quotedprintable.NewReader() fails on, such as when a
+// line made of a single '=' ends the data. As long as more data may follow,
+// such an end is held back.
+type softBreakTrimmer struct {
+	r io.Reader
+
+	// The data ready to be returned, read into buffer, the end of the data
+	// read held back, and the error to return once they are returned
+	buffer, out, held []byte
+	err               error
+}
+
+func (t *softBreakTrimmer) Read(p []byte) (int, error) {
+
+	for len(t.out) == 0 && t.err == nil {
+
+		if t.buffer == nil {
+			t.buffer = make([]byte, 4096)
+		}
+		n, err := t.r.Read(t.buffer)
+		data := t.buffer[:n]
+		if len(t.held) > 0 {
+			data = append(t.held, data...)
+		}
+
+		// The end held back is copied, as the buffer is reused
+		t.held = nil
+		if i := bytes.LastIndexByte(data, '='); i >= 0 && len(bytes.Trim(data[i+1:], " \t\r")) == 0 {
+			data, t.held = data[:i], append([]byte(nil), data[i:]...)
+		}
+		t.out, t.err = data, err
+
+	}
+
+	if len(t.out) > 0 {
+		n := copy(p, t.out)
+		t.out = t.out[n:]
+		return n, nil
+	}
+
+	return 0, t.err
+
+}
+
+// newlineTransformer converts the CRLF and LF line endings to newline. A CR
+// which isn't followed by a LF is left as is.
+type newlineTransformer struct {
+	transform.NopResetter
+	newline []byte
+}
+
+func (t newlineTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+
+	for nSrc < len(src) {
+
+		out, consumed := src[nSrc:nSrc+1], 1
+		switch src[nSrc] {
+		case '\r':
+			// Wait for the next byte to know if it ends a line
+			if nSrc+1 == len(src) && !atEOF {
+				return nDst, nSrc, transform.ErrShortSrc
+			}
+			if nSrc+1 < len(src) && src[nSrc+1] == '\n' {
+				out, consumed = t.newline, 2
+			}
+		case '\n':
+			out = t.newline
+		}
+
+		if nDst+len(out) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], out)
+		nSrc += consumed
+
+	}
+
+	return nDst, nSrc, nil
+
+}
+
+// newlineReader returns a reader converting the line endings of the text
+// read from r, in charset, to newline. R is returned as is for the charsets
+// whose line endings aren't made of single bytes, such as UTF-16.
+func newlineReader(charset string, newline string, r io.Reader) io.Reader {
+
+	if !singleByteLines(charset) {
+		return r
+	}
+
+	return transform.NewReader(r, newlineTransformer{newline: []byte(newline)})
+
+}
+
+// singleByteLines tells if the line endings of the text in charset are made
+// of single bytes, CRLF or LF, unlike in UTF-16 for instance.
+func singleByteLines(charset string) bool {
+
+	charset = strings.ToLower(charset)
+	for _, prefix := range []string{"utf-16", "utf-32", "ucs-"} {
+		if strings.HasPrefix(charset, prefix) {
+			return false
+		}
+	}
+
+	return true
+
+}
@@ -0,0 +1,191 @@
+package mimeparse
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// singlePartMessage returns a message which isn't multipart, whose body is
+// data encoded with encoding, as its Content-Transfer-Encoding says.
+func singlePartMessage(contentType, encoding, data string) string {
+
+	return "From: alice@example.com\r\nContent-Type: " + contentType + "\r\n" +
+		"Content-Transfer-Encoding: " + encoding + "\r\n\r\n" + data
+
+}
+
+func TestTransferEncodings(t *testing.T) {
+
+	tests := []struct {
+		encoding string
+		data     string
+		want     string
+	}{
+		{"7bit", "plain text\r\n", "plain text\r\n"},
+		{"7BIT", "plain text\r\n", "plain text\r\n"},
+		{"8Bit", "caf\xe9\r\n", "caf\xe9\r\n"},
+		{" 8bit ", "caf\xe9\r\n", "caf\xe9\r\n"},
+		{"BINARY", "\x00\x01\x02", "\x00\x01\x02"},
+		{"Binary", "\x00\x01\x02", "\x00\x01\x02"},
+		{"Base64", "aGVsbG8=\r\n", "hello"},
+		{"QUOTED-PRINTABLE", "caf=C3=A9=\r\n", "café"},
+		{`"base64" (encoded)`, "aGVsbG8=\r\n", "hello"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.encoding, func(t *testing.T) {
+
+			message := singlePartMessage("application/octet-stream", test.encoding, test.data)
+			parts, _, err := parseTest(t, strings.NewReader(message), Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(parts) != 1 || parts[0].data != test.want {
+				t.Errorf("got %q, want %q", parts, test.want)
+			}
+
+		})
+	}
+
+	// An unknown encoding is an error, rather than written as is
+	message := singlePartMessage("application/octet-stream", "x-uuencode", "begin 644 x\r\n")
+	parts, _, err := parseTest(t, strings.NewReader(message), Options{})
+	var decode_error *DecodeError
+	if !errors.As(err, &decode_error) || !strings.Contains(err.Error(), "x-uuencode") {
+		t.Errorf("got error %v, want a DecodeError", err)
+	}
+	if len(parts) != 0 {
+		t.Errorf("got parts %q, want none", parts)
+	}
+
+}
+
+// The base64 data is decoded whatever the whitespaces and line breaks within
+// it, such as the 76 columns lines the mailers write.
+func TestBase64Wrapped(t *testing.T) {
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var wrapped strings.Builder
+	for len(encoded) > 76 {
+		wrapped.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	wrapped.WriteString(encoded + "\r\n")
+
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"76 columns", wrapped.String(), string(data)},
+		{"LF", strings.ReplaceAll(wrapped.String(), "\r\n", "\n"), string(data)},
+		{"spaces and tabs", "aGVs bG8g\td29y\r\n  bGQ=\r\n", "hello world"},
+		{"padding on its own line", "aGVsbG8gd29ybGQ\r\n=\r\n", "hello world"},
+		{"double padding", "aGVsbG8gd29ybA\r\n==\r\n\r\n", "hello worl"},
+		{"trailing blank lines", "aGVsbG8=\r\n\r\n \r\n", "hello"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			message := singlePartMessage("application/octet-stream", "base64", test.data)
+			m, err := ParseEmail(strings.NewReader(message), Options{DryRun: true, KeepAttachments: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(m.Parts) != 1 || string(m.Parts[0].content) != test.want {
+				t.Errorf("got parts %v, want %q", m.Parts, test.want)
+			}
+			if m.Parts[0].DecodeStatus != DecodeOK {
+				t.Errorf("got DecodeStatus %q, want %q", m.Parts[0].DecodeStatus, DecodeOK)
+			}
+
+		})
+	}
+
+}
+
+// The base64 data wrongly sent with the URL-safe alphabet, or without its
+// padding, is decoded all the same, the variant being recorded.
+func TestBase64Variants(t *testing.T) {
+
+	want := "\xfb\xff\xbfhi"
+
+	tests := []struct {
+		data     string
+		variant  string
+		remapped int
+		status   DecodeStatus
+	}{
+		{"+/+/aGk=\r\n", "", 0, DecodeOK},
+		{"+/+/aGk\r\n", "RawStdEncoding", 0, DecodeRepaired},
+		{"-_-_aGk=\r\n", "URLEncoding", 4, DecodeRepaired},
+		{"-_-_\r\naGk\r\n", "RawURLEncoding", 4, DecodeRepaired},
+		{"+/-_aGk=\r\n", "URLEncoding", 2, DecodeRepaired},
+	}
+
+	for _, test := range tests {
+		t.Run(test.data, func(t *testing.T) {
+
+			message := singlePartMessage("application/octet-stream", "base64", test.data)
+			m, err := ParseEmail(strings.NewReader(message), Options{DryRun: true, KeepAttachments: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			part := m.Parts[0]
+			if string(part.content) != want || part.Base64Variant != test.variant {
+				t.Errorf("got %q with variant %q, want %q with %q", part.content, part.Base64Variant, want, test.variant)
+			}
+			if part.Base64Remapped != test.remapped || part.DecodeStatus != test.status {
+				t.Errorf("got %d remapped, DecodeStatus %q, want %d, %q", part.Base64Remapped, part.DecodeStatus, test.remapped, test.status)
+			}
+
+		})
+	}
+
+}
+
+// With LenientBase64, the invalid characters are dropped from the base64
+// data rather than failing, which is reported.
+func TestLenientBase64(t *testing.T) {
+
+	tests := []struct {
+		data    string
+		want    string
+		dropped int
+		status  DecodeStatus
+	}{
+		{"aGVs*bG8g\x00d29y!bGQ=\r\n", "hello world", 3, DecodeRepaired},
+		{"aGVs=bG8=\r\n", "hello", 1, DecodeRepaired},
+		{"aGVs==\r\nbG8=\r\n", "hello", 2, DecodeRepaired},
+		{"aGVsbG8=\r\n", "hello", 0, DecodeOK},
+	}
+
+	for _, test := range tests {
+		message := singlePartMessage("application/octet-stream", "base64", test.data)
+		m, err := ParseEmail(strings.NewReader(message), Options{DryRun: true, KeepAttachments: true, LenientBase64: true})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.data, err)
+			continue
+		}
+		part := m.Parts[0]
+		if string(part.content) != test.want || part.Base64Dropped != test.dropped || part.DecodeStatus != test.status {
+			t.Errorf("%q: got %q, %d dropped, status %q, want %q, %d dropped, %q", test.data, part.content, part.Base64Dropped, part.DecodeStatus, test.want, test.dropped, test.status)
+		}
+	}
+
+	// Otherwise, the part can't be decoded
+	message := singlePartMessage("application/octet-stream", "base64", tests[0].data)
+	m, err := ParseEmail(strings.NewReader(message), Options{DryRun: true})
+	var decode_error *DecodeError
+	if !errors.As(err, &decode_error) || m.Parts[0].DecodeStatus != DecodeFailed {
+		t.Errorf("got error %v, status %q, want a DecodeError", err, m.Parts[0].DecodeStatus)
+	}
+
+}
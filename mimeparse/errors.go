@@ -0,0 +1,87 @@
+package mimeparse
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooLarge is returned, wrapped, for a part that can't be written because
+// Options.MaxTotalBytes or Options.MaxPartSize would be exceeded.
+var ErrTooLarge = errors.New("size limit exceeded")
+
+// ErrTooManyParts is returned, wrapped, when a message has more parts than
+// Options.MaxParts.
+var ErrTooManyParts = errors.New("too many MIME parts")
+
+// ErrPartTimeout is returned, wrapped, for a part which couldn't be read and
+// decoded within Options.PartTimeout.
+var ErrPartTimeout = errors.New("part timeout exceeded")
+
+// ErrNotMIME is returned, wrapped along with the cause, for data that can't
+// be read as an email at all, such as a message whose header is malformed.
+var ErrNotMIME = errors.New("not a MIME message")
+
+// ErrNoMessages is returned, wrapped, by ParseMbox for a mailbox without any
+// message, such as data which isn't in the mbox format, whose messages start
+// with a "From " line.
+var ErrNoMessages = errors.New("no message in mbox")
+
+// ErrNoBoundary is returned, wrapped, for a multipart part, or message, whose
+// Content-Type has no boundary parameter, or an empty one, so its MIME parts
+// can't be told apart.
+var ErrNoBoundary = errors.New("missing multipart boundary")
+
+// ErrTruncated is returned, wrapped, for a multipart part, or message, whose
+// data ends before its closing boundary, such as a message cut short, as
+// opposed to the end of its parts. The parts read so far are extracted.
+var ErrTruncated = errors.New("truncated multipart data")
+
+// DecodeError is returned, wrapped, for a part whose data can't be read or
+// decoded, such as a part with broken base64 or an unknown
+// Content-Transfer-Encoding.
+type DecodeError struct {
+
+	// Filename is the name of the file of the part.
+	Filename string
+
+	// Step describes what was being done, such as "decoding base64", if the
+	// failure happened while the data of the part was read.
+	Step string
+
+	// Err is the cause of the failure.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+
+	if len(e.Step) == 0 {
+		return fmt.Sprintf("decoding %q: %v", e.Filename, e.Err)
+	}
+
+	return fmt.Sprintf("%s for %q: %v", e.Step, e.Filename, e.Err)
+
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError is returned, wrapped, for a part that can't be written, such as
+// when its file can't be created, or is too large, see ErrTooLarge.
+type WriteError struct {
+
+	// Path is the path of the file, or the name of the archive entry, the
+	// part was written to.
+	Path string
+
+	// Err is the cause of the failure.
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("writing MIME part to %q: %v", e.Path, e.Err)
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,58 @@
+package mimeparse
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The failures are told apart with errors.Is and errors.As.
+func TestErrors(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		opts    Options
+		is      error
+		decode  bool
+		write   bool
+	}{
+		{"not MIME", "no header at all", Options{DryRun: true}, ErrNotMIME, false, false},
+		{"no boundary", "From: alice@example.com\r\nContent-Type: multipart/mixed\r\n\r\nbody\r\n", Options{DryRun: true}, ErrNoBoundary, false, false},
+		{"decode", singlePartMessage("application/pdf", "base64", "JVBE!!!Ri0=\r\n"), Options{DryRun: true}, nil, true, false},
+		{"unknown encoding", singlePartMessage("application/pdf", "x-unknown", "data\r\n"), Options{DryRun: true}, nil, true, false},
+		{"write", singlePartMessage("application/pdf", "7bit", "data\r\n"), Options{OutputDir: filepath.Join(file, "out"), Verbosity: Quiet}, nil, false, true},
+		{"too large", singlePartMessage("application/pdf", "7bit", "data\r\n"), Options{DryRun: true, MaxPartSize: 2}, ErrTooLarge, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			_, err := ParseEmail(strings.NewReader(test.message), test.opts)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if test.is != nil && !errors.Is(err, test.is) {
+				t.Errorf("got error %v, want %v", err, test.is)
+			}
+
+			var decode_error *DecodeError
+			if errors.As(err, &decode_error) != test.decode {
+				t.Errorf("got error %v, DecodeError %v, want %v", err, !test.decode, test.decode)
+			}
+			var write_error *WriteError
+			if errors.As(err, &write_error) != test.write {
+				t.Errorf("got error %v, WriteError %v, want %v", err, !test.write, test.write)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,79 @@
+package mimeparse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// The types of the events written to Options.Events.
+const (
+	// PartEvent describes a MIME part, extracted or skipped.
+	PartEvent = "part"
+
+	// MessageEvent describes a message, once all its MIME parts are done.
+	MessageEvent = "message"
+)
+
+// Event is a line of the newline-delimited JSON written to Options.Events:
+// one PartEvent for each MIME part of a message, in the order of
+// Message.Parts, followed by one MessageEvent for the message itself.
+type Event struct {
+
+	// Type is PartEvent or MessageEvent.
+	Type string `json:"type"`
+
+	// Message is the position of the message in the mailbox, from 1, with
+	// ParseMbox, and zero otherwise.
+	Message int `json:"message,omitempty"`
+
+	// Part is the description of the part of a PartEvent.
+	Part *PartMeta `json:"part,omitempty"`
+
+	// The main headers of the message of a MessageEvent, decoded, along
+	// with its number of parts and its directory, as given by Message.
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Dir       string `json:"dir,omitempty"`
+	Parts     int    `json:"parts,omitempty"`
+
+	// Error is the error returned for the message of a MessageEvent, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// writeEvents writes the events of the message m to w, see Event, m being
+// nil if the message couldn't be read at all, and err the error returned for
+// it. Index is the position of the message in its mailbox, if any.
+func writeEvents(w io.Writer, m *Message, index int, err error) error {
+
+	encoder := json.NewEncoder(w)
+
+	event := Event{Type: MessageEvent, Message: index}
+	if m != nil {
+		for i := range m.Parts {
+			if e := encoder.Encode(Event{Type: PartEvent, Message: index, Part: &m.Parts[i]}); e != nil {
+				return fmt.Errorf("writing events: %w", e)
+			}
+		}
+		event.From = m.From
+		event.To = m.To
+		event.Date = m.Date
+		event.Subject = m.Subject
+		event.MessageID = m.Headers.MessageID
+		event.Dir = m.Dir
+		event.Parts = m.TotalParts
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	if e := encoder.Encode(event); e != nil {
+		return fmt.Errorf("writing events: %w", e)
+	}
+
+	return nil
+
+}
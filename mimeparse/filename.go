@@ -0,0 +1,464 @@
+package mimeparse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BuildFileName builds a file name for a MIME part, using information extracted from
+// the part itself, as well as a radix and an index given as parameters.
+func BuildFileName(part *multipart.Part, radix string, index int) string {
+	return buildFileName(part.Header, radix, index, nil)
+}
+
+// buildFileName does the job of BuildFileName from the header of the part only,
+// so it can also be used for the body of a message that isn't multipart. The
+// extension is looked up in extensions first, see Options.Extensions.
+func buildFileName(header textproto.MIMEHeader, radix string, index int, extensions map[string]string) (filename string) {
+
+	// 1st try to get the true file name if there is one in Content-Disposition
+	filename = declaredFileName(header)
+	if len(filename) > 0 {
+		return
+	}
+
+	// If no defaut filename defined, try to build one of the following format :
+	// "radix-index.ext" where extension is comuputed from the Content-Type of the part
+	mediaType, _, err := parseContentType(header)
+	if err == nil {
+		return fmt.Sprintf("%s-%d%s", radix, index, extensionByType(mediaType, extensions))
+	}
+
+	// A malformed Content-Type, such as "text/" or "; charset=utf-8", tells
+	// nothing of the type of the part
+	return fmt.Sprintf("%s-%d%s", radix, index, defaultExtension)
+
+}
+
+// declaredFileName returns the file name given to the part described by
+// header in its Content-Disposition, made safe to be used as is, see
+// sanitizeFileName, or an empty string if there is none. Non ASCII file names
+// are often encoded using RFC 2047, just like the headers of the message.
+func declaredFileName(header textproto.MIMEHeader) string {
+
+	filename := dispositionFileName(header.Get("Content-Disposition"))
+	if len(filename) == 0 {
+		return ""
+	}
+	filename, _ = DecodeHeader(filename)
+
+	return sanitizeFileName(filename)
+
+}
+
+// preferredExtensions are the extensions given to the parts of the most common
+// types, rather than the first one returned by mime.ExtensionsByType(), which
+// depends on the system and is often an unusual one, such as ".jpe" for
+// image/jpeg or ".conf" for text/plain.
+var preferredExtensions = map[string]string{
+	"application/msword":       ".doc",
+	"application/octet-stream": ".bin",
+	"application/pdf":          ".pdf",
+	"application/pkcs7-mime":   ".p7m",
+	"application/postscript":   ".ps",
+	"application/xml":          ".xml",
+	"application/zip":          ".zip",
+	"audio/mpeg":               ".mp3",
+	"image/jpeg":               ".jpg",
+	"image/tiff":               ".tiff",
+	"message/rfc822":           ".eml",
+	"text/calendar":            ".ics",
+	"text/html":                ".html",
+	"text/plain":               ".txt",
+	"text/xml":                 ".xml",
+	"video/mpeg":               ".mpeg",
+}
+
+// defaultExtension is the extension of the files written for the parts of a
+// type no extension is known for.
+const defaultExtension = ".bin"
+
+// extensionByType returns the extension of the files of type mediaType, as
+// given by extensions, or by preferredExtensions, or else the first one known
+// by mime.ExtensionsByType(), if any. Otherwise defaultExtension is returned.
+func extensionByType(mediaType string, extensions map[string]string) string {
+
+	mediaType = strings.ToLower(mediaType)
+	if ext, ok := extensions[mediaType]; ok {
+		return ext
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+
+	// mime.ExtensionsByType() returns no error, but no extension either,
+	// for the types it doesn't know
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return defaultExtension
+	}
+
+	return exts[0]
+
+}
+
+// sniffLen is the number of bytes of decoded data looked at to sniff the
+// type of a part, all that http.DetectContentType() considers.
+const sniffLen = 512
+
+// needsSniffing tells if the type of the part described by header has to be
+// sniffed from its data to name it: the part has no file name of its own, and
+// its Content-Type is malformed, generic (application/octet-stream), or
+// unknown to extensionByType(). A part without a Content-Type is text/plain,
+// see defaultContentType.
+func needsSniffing(header textproto.MIMEHeader, extensions map[string]string) bool {
+
+	if len(declaredFileName(header)) > 0 {
+		return false
+	}
+
+	mediaType, _, err := parseContentType(header)
+	if err != nil || mediaType == "application/octet-stream" {
+		return true
+	}
+
+	if _, ok := extensions[strings.ToLower(mediaType)]; ok {
+		return false
+	}
+	if _, ok := preferredExtensions[strings.ToLower(mediaType)]; ok {
+		return false
+	}
+
+	exts, err := mime.ExtensionsByType(mediaType)
+	return err != nil || len(exts) == 0
+
+}
+
+// sniffFileName builds a file name for the part described by header, like
+// buildFileName, but upon the type sniffed from data, the start of the decoded
+// data of the part. An empty string is returned if nothing more specific than
+// application/octet-stream could be sniffed. Data must not be empty, as it
+// would be taken for text.
+func sniffFileName(header textproto.MIMEHeader, data []byte, radix string, index int, extensions map[string]string) string {
+
+	sniffed, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || sniffed == "application/octet-stream" {
+		return ""
+	}
+
+	sniffed_header := make(textproto.MIMEHeader, len(header))
+	for key, values := range header {
+		sniffed_header[key] = values
+	}
+	sniffed_header.Set("Content-Type", sniffed)
+
+	return buildFileName(sniffed_header, radix, index, extensions)
+
+}
+
+// uniqueName returns filename, or if it was already given to another part of
+// the message, filename with a " (1)", " (2)", ... suffix before its extension,
+// so a part doesn't overwrite the file of another one. Names differing only
+// by their case are considered the same, for the case insensitive filesystems.
+func (p *parser) uniqueName(filename string) string {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.used == nil {
+		p.used = make(map[string]bool)
+	}
+
+	ext := filepath.Ext(filename)
+	name := filename
+	for i := 1; p.used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(filename, ext), i, ext)
+	}
+	p.used[strings.ToLower(name)] = true
+
+	return name
+
+}
+
+// sanitizeFileName returns the base name of filename, the file name given by
+// the email, so it can't be used to write outside of the output directory.
+// Both '/' and '\' are handled as path separators, whatever the OS. An empty
+// string is returned if there is no usable name left, or if filename holds
+// a NUL or a control character.
+func sanitizeFileName(filename string) string {
+
+	if strings.IndexFunc(filename, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		return ""
+	}
+
+	// Keep only the last element of the path
+	filename = strings.ReplaceAll(filename, "\\", "/")
+	filename = strings.TrimSpace(filename[strings.LastIndex(filename, "/")+1:])
+
+	if filename == "." || filename == ".." {
+		return ""
+	}
+
+	return filename
+
+}
+
+// maxFileNameLen is the maximum length, in bytes, of the file names, below
+// the 255 bytes most file systems allow, leaving room for the suffixes added
+// by uniqueName() and Options.WriteRaw.
+const maxFileNameLen = 200
+
+// shortenFileName truncates filename to maxFileNameLen bytes, if longer,
+// keeping its extension. The name is ended with a hash of the whole name, so
+// names which only differ by their end are still told apart.
+func shortenFileName(filename string) string {
+
+	if len(filename) <= maxFileNameLen {
+		return filename
+	}
+
+	// An extension this long is more likely part of the name
+	ext := filepath.Ext(filename)
+	if len(ext) > 16 {
+		ext = ""
+	}
+
+	sum := sha256.Sum256([]byte(filename))
+	suffix := "-" + hex.EncodeToString(sum[:4]) + ext
+
+	// Cut the name on a rune boundary
+	base := filename[:maxFileNameLen-len(suffix)]
+	for len(base) > 0 && !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+
+	return base + suffix
+
+}
+
+// reservedNames are the names Windows reserves for devices, which can't be
+// used for files, even with an extension, whatever their case.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// portableFileName returns filename, as returned by sanitizeFileName, made
+// valid on Windows, see Options.PortableNames. The dots and spaces ending the
+// name, which Windows drops, are replaced with '_' too.
+func portableFileName(filename string) string {
+
+	filename = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	if trimmed := strings.TrimRight(filename, ". "); len(trimmed) < len(filename) {
+		filename = trimmed + strings.Repeat("_", len(filename)-len(trimmed))
+	}
+
+	base, ext, _ := strings.Cut(filename, ".")
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		filename = base + "_"
+		if len(ext) > 0 {
+			filename += "." + ext
+		}
+	}
+
+	return filename
+
+}
+
+// dispositionFileName returns the value of the "filename" parameter of the
+// Content-Disposition header value disposition. Unlike mime.ParseMediaType(),
+// it doesn't give up on the whole header because of another malformed parameter,
+// and it handles all the forms of RFC 2231: continuations ("filename*0",
+// "filename*1", ...) and charset-tagged values ("filename*=UTF-8'en'%e2%82%ac.txt"),
+// in the charsets known to decodeCharset. The RFC 2231 forms take precedence over a plain
+// "filename" parameter.
+func dispositionFileName(disposition string) string {
+
+	type segment struct {
+		value   string
+		encoded bool
+	}
+
+	var plain, extended string
+	segments := make(map[int]segment)
+
+	for _, param := range splitParams(disposition) {
+
+		key, value, found := strings.Cut(param, "=")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = unquote(strings.TrimSpace(value))
+
+		switch {
+		case key == "filename":
+			plain = value
+		case key == "filename*":
+			extended = value
+		case strings.HasPrefix(key, "filename*"):
+			n := strings.TrimPrefix(key, "filename*")
+			encoded := strings.HasSuffix(n, "*")
+			if i, err := strconv.Atoi(strings.TrimSuffix(n, "*")); err == nil && i >= 0 {
+				segments[i] = segment{value, encoded}
+			}
+		}
+
+	}
+
+	if len(extended) > 0 {
+		if filename, ok := decodeRFC2231(extended, nil); ok {
+			return filename
+		}
+	}
+
+	// Reassemble the continuations in order, up to the first one missing. Only
+	// the first segment holds the charset, but any segment may be encoded.
+	if first, ok := segments[0]; ok {
+		if !first.encoded {
+			var b strings.Builder
+			for i := 0; ; i++ {
+				s, ok := segments[i]
+				if !ok {
+					break
+				}
+				if s.encoded {
+					data, _ := percentDecode(s.value)
+					b.Write(data)
+				} else {
+					b.WriteString(s.value)
+				}
+			}
+			return b.String()
+		}
+
+		var rest []string
+		for i := 1; ; i++ {
+			s, ok := segments[i]
+			if !ok {
+				break
+			}
+			if !s.encoded {
+				s.value = url.PathEscape(s.value)
+			}
+			rest = append(rest, s.value)
+		}
+		if filename, ok := decodeRFC2231(first.value, rest); ok {
+			return filename
+		}
+	}
+
+	return plain
+
+}
+
+// decodeRFC2231 decodes value, a RFC 2231 charset-tagged value of the form
+// "charset'language'percent-encoded-data", followed by the percent-encoded data
+// of its continuations.
+func decodeRFC2231(value string, continuations []string) (string, bool) {
+
+	parts := strings.SplitN(value, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	data, err := percentDecode(parts[2] + strings.Join(continuations, ""))
+	if err != nil {
+		return "", false
+	}
+
+	return decodeCharset(parts[0], data)
+
+}
+
+// percentDecode decodes the %XX escapes of s. Unlike url.PathUnescape(), it
+// accepts any byte that isn't part of an escape.
+func percentDecode(s string) ([]byte, error) {
+
+	data := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			data = append(data, s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return nil, fmt.Errorf("truncated escape in %q", s)
+		}
+		b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid escape in %q", s)
+		}
+		data = append(data, byte(b))
+		i += 2
+	}
+
+	return data, nil
+
+}
+
+// splitParams splits the parameters of a header value on the semi-colons
+// which aren't part of a quoted string. The first element is the value itself,
+// such as "attachment" for a Content-Disposition.
+func splitParams(value string) []string {
+
+	var params []string
+	quoted, escaped := false, false
+	start := 0
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && value[i] == '\\':
+			escaped = true
+		case value[i] == '"':
+			quoted = !quoted
+		case !quoted && value[i] == ';':
+			params = append(params, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(params, value[start:])
+
+}
+
+// unquote removes the quotes around a quoted string value, and unescapes the
+// characters escaped with a backslash in it. Other values are returned as is.
+func unquote(value string) string {
+
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+
+	var b strings.Builder
+	value = value[1 : len(value)-1]
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+
+}
@@ -0,0 +1,379 @@
+package mimeparse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// dispositionMessage returns a message made of a single attachment, whose
+// Content-Disposition is disposition.
+func dispositionMessage(disposition string) string {
+
+	return "From: alice@example.com\r\nContent-Type: application/octet-stream\r\n" +
+		"Content-Disposition: " + disposition + "\r\n\r\ndata\r\n"
+
+}
+
+func TestSanitizeFileName(t *testing.T) {
+
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../x", "x"},
+		{`..\..\x`, "x"},
+		{"/etc/x", "x"},
+		{`C:\Windows\x`, "x"},
+		{"dir/../x", "x"},
+		{"..", ""},
+		{".", ""},
+		{"../..", ""},
+		{"x/", ""},
+		{"a\x00b.txt", ""},
+		{"a\nb.txt", ""},
+		{"  x.txt  ", "x.txt"},
+	}
+
+	for _, test := range tests {
+		if got := sanitizeFileName(test.filename); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.filename, got, test.want)
+		}
+	}
+
+}
+
+// The parts named by the message with a path, even encoded, are written
+// inside the output directory, under their base name.
+func TestTraversalFileNames(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		disposition string
+		want        string
+	}{
+		{"relative", `attachment; filename="../../x"`, "x"},
+		{"backslashes", `attachment; filename="..\\..\\x"`, "x"},
+		{"absolute", `attachment; filename="/etc/x"`, "x"},
+		{"dot dot", `attachment; filename=".."`, "body-1.txt"},
+		{"RFC 2047", `attachment; filename="=?utf-8?q?=2E=2E=2F=2E=2E=2Fx?="`, "x"},
+		{"RFC 2047 backslashes", `attachment; filename="=?utf-8?b?Li5cLi5ceA==?="`, "x"},
+		{"RFC 2231", `attachment; filename*=utf-8''%2E%2E%2F%2E%2E%2Fx`, "x"},
+		{"RFC 2231 continuations", `attachment; filename*0*=utf-8''%2E%2E; filename*1="/../x"`, "x"},
+		{"RFC 2231 NUL", `attachment; filename*=utf-8''x%00.sh`, "body-1.txt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			root := t.TempDir()
+			dir := filepath.Join(root, "a", "b", "out")
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := ParseEmail(strings.NewReader(dispositionMessage(test.disposition)), Options{OutputDir: dir, Verbosity: Quiet})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(m.Parts) != 1 || m.Parts[0].Filename != test.want {
+				t.Fatalf("got parts %v, want %q", m.Parts, test.want)
+			}
+
+			// The only file written is the one of the part, in dir
+			err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+				if err == nil && !entry.IsDir() && path != filepath.Join(dir, test.want) {
+					t.Errorf("unexpected file %q", path)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+		})
+	}
+
+}
+
+// The file names encoded as RFC 2047 words, in B or Q encoding, are decoded.
+func TestEncodedFileNames(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		disposition string
+		want        string
+	}{
+		{"B UTF-8", `attachment; filename="=?UTF-8?B?6KuL5rGC5pu4LnBkZg==?="`, "請求書.pdf"},
+		{"Q UTF-8", `attachment; filename="=?utf-8?Q?r=C3=A9sum=C3=A9.pdf?="`, "résumé.pdf"},
+		{"Q ISO-8859-1", `attachment; filename="=?ISO-8859-1?Q?r=E9sum=E9.pdf?="`, "résumé.pdf"},
+		{"B ISO-2022-JP", `attachment; filename="=?ISO-2022-JP?B?GyRCO3FOQRsoQi50eHQ=?="`, "資料.txt"},
+		{"words", `attachment; filename="=?UTF-8?Q?caf=C3=A9?= =?UTF-8?Q?_cr=C3=A8me.txt?="`, "café crème.txt"},
+		{"plain", `attachment; filename="report.pdf"`, "report.pdf"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parts, _, err := parseTest(t, strings.NewReader(dispositionMessage(test.disposition)), Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(parts) != 1 || parts[0].name != test.want {
+				t.Errorf("got parts %q, want %q", parts, test.want)
+			}
+
+		})
+	}
+
+}
+
+// The RFC 2231 forms of the file names, continuations and charset-tagged
+// values, are reassembled and decoded.
+func TestDispositionFileName(t *testing.T) {
+
+	tests := []struct {
+		disposition string
+		want        string
+	}{
+		{`attachment; filename*=UTF-8''%e2%82%ac%20rates.txt`, "€ rates.txt"},
+		{`attachment; filename*=iso-8859-1'fr'r%E9sum%E9.pdf`, "résumé.pdf"},
+		{`attachment; filename*0="a very long file name "; filename*1="split in two.txt"`, "a very long file name split in two.txt"},
+		{`attachment; filename*0*=UTF-8''caf%C3%A9%20; filename*1=cr; filename*2*=%C3%A8me.txt`, "café crème.txt"},
+		{`attachment; filename*1="second.txt"; filename*0="first-"`, "first-second.txt"},
+		{`attachment; filename*0="first-"; filename*2="third.txt"`, "first-"},
+		{`attachment; filename="plain.txt"; filename*=UTF-8''encoded.txt`, "encoded.txt"},
+		{`attachment; filename*=UTF-8''%zz.txt; filename="plain.txt"`, "plain.txt"},
+		{`attachment; size=12; filename="semi;colon.txt"`, "semi;colon.txt"},
+		{`attachment; filename="quoted \"name\".txt"`, `quoted "name".txt`},
+		{`attachment`, ""},
+	}
+
+	for _, test := range tests {
+		if got := dispositionFileName(test.disposition); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.disposition, got, test.want)
+		}
+	}
+
+	parts, _, err := parseTest(t, strings.NewReader(dispositionMessage(tests[3].disposition)), Options{})
+	if err != nil || len(parts) != 1 || parts[0].name != tests[3].want {
+		t.Errorf("got parts %q, %v, want %q", parts, err, tests[3].want)
+	}
+
+}
+
+func TestPercentDecode(t *testing.T) {
+
+	tests := []struct {
+		s    string
+		want string
+		ok   bool
+	}{
+		{"a%20b", "a b", true},
+		{"%E2%82%ac", "€", true},
+		{"100% sure", "", false},
+		{"end%2", "", false},
+		{"plain", "plain", true},
+	}
+
+	for _, test := range tests {
+		data, err := percentDecode(test.s)
+		if (err == nil) != test.ok || string(data) != test.want {
+			t.Errorf("%q: got %q, %v, want %q", test.s, data, err, test.want)
+		}
+	}
+
+}
+
+// The parts named alike are written to files of their own, rather than
+// overwriting each other.
+func TestUniqueNames(t *testing.T) {
+
+	var b strings.Builder
+	b.WriteString("From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n")
+	for _, part := range []struct{ name, data string }{
+		{"invoice.pdf", "first"}, {"invoice.pdf", "second"}, {"INVOICE.pdf", "third"},
+		{"invoice (1).pdf", "fourth"}, {"README", "fifth"}, {"README", "sixth"},
+	} {
+		fmt.Fprintf(&b, "--XX\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=%q\r\n\r\n%s\r\n", part.name, part.data)
+	}
+	b.WriteString("--XX--\r\n")
+
+	dir := t.TempDir()
+	if _, err := ParseEmail(strings.NewReader(b.String()), Options{OutputDir: dir, Verbosity: Quiet}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"invoice.pdf": "first", "invoice (1).pdf": "second", "INVOICE (2).pdf": "third",
+		"invoice (1) (1).pdf": "fourth", "README": "fifth", "README (1)": "sixth",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+}
+
+// The parts without a file name of their own are numbered upon their
+// position at their level, so they are written to distinct files.
+func TestUnnamedParts(t *testing.T) {
+
+	var b strings.Builder
+	b.WriteString("From: alice@example.com\r\nContent-Type: multipart/related; boundary=XX\r\n\r\n")
+	for i := 1; i <= 3; i++ {
+		fmt.Fprintf(&b, "--XX\r\nContent-Type: image/png\r\n\r\nimage %d\r\n", i)
+	}
+	b.WriteString("--XX--\r\n")
+
+	dir := t.TempDir()
+	if _, err := ParseEmail(strings.NewReader(b.String()), Options{OutputDir: dir, Verbosity: Quiet}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("XX-%d.png", i)
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != fmt.Sprintf("image %d", i) {
+			t.Errorf("%s: got %q, %v, want %q", name, data, err, fmt.Sprintf("image %d", i))
+		}
+	}
+
+}
+
+// The parts without a meaningful Content-Type are named upon the type sniffed
+// from their decoded data, unless they are empty or can't be decoded.
+func TestSniffedFileNames(t *testing.T) {
+
+	tests := []struct {
+		encoding string
+		data     string
+		want     string
+	}{
+		{"7bit", "%PDF-1.4\r\n", "body-1.pdf"},
+		{"7bit", "plain text\r\n", "body-1.txt"},
+		{"base64", "JVBERi0xLjQ=\r\n", "body-1.pdf"},
+		{"7bit", "", "body-1.bin"},
+		{"base64", "", "body-1.bin"},
+		{"base64", "!!!!\r\n", "body-1.bin"},
+	}
+
+	for _, test := range tests {
+		message := singlePartMessage("application/octet-stream", test.encoding, test.data)
+		m, _ := ParseEmail(strings.NewReader(message), Options{DryRun: true})
+		if len(m.Parts) != 1 || m.Parts[0].Filename != test.want {
+			t.Errorf("%s %q: got parts %v, want %q", test.encoding, test.data, m.Parts, test.want)
+		}
+	}
+
+}
+
+// With PortableNames, the file names are made valid on Windows.
+func TestPortableFileName(t *testing.T) {
+
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"COM1", "COM1_"},
+		{"com1.txt", "com1_.txt"},
+		{"Nul.tar.gz", "Nul_.tar.gz"},
+		{"a:b?.txt", "a_b_.txt"},
+		{`<"quoted">|*.txt`, "__quoted____.txt"},
+		{"trailing. ", "trailing__"},
+		{"COM10.txt", "COM10.txt"},
+		{"report.pdf", "report.pdf"},
+	}
+
+	for _, test := range tests {
+		if got := portableFileName(test.filename); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.filename, got, test.want)
+		}
+	}
+
+	for _, test := range tests[:4] {
+		message := dispositionMessage(fmt.Sprintf("attachment; filename=%q", test.filename))
+		parts, _, err := parseTest(t, strings.NewReader(message), Options{PortableNames: true})
+		if err != nil || len(parts) != 1 || parts[0].name != test.want {
+			t.Errorf("%q: got parts %q, %v, want %q", test.filename, parts, err, test.want)
+		}
+	}
+
+}
+
+// The file names too long for the file systems are shortened, keeping their
+// extension, and still told apart.
+func TestLongFileNames(t *testing.T) {
+
+	long := strings.Repeat("a", 500)
+	dir := t.TempDir()
+	var b strings.Builder
+	b.WriteString("From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n")
+	for i, name := range []string{long + "1.pdf", long + "2.pdf", strings.Repeat("é", 300) + ".txt"} {
+		fmt.Fprintf(&b, "--XX\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=%q\r\n\r\npart %d\r\n", name, i+1)
+	}
+	b.WriteString("--XX--\r\n")
+
+	m, err := ParseEmail(strings.NewReader(b.String()), Options{OutputDir: dir, Verbosity: Quiet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Parts) != 3 || m.Parts[0].Filename == m.Parts[1].Filename {
+		t.Fatalf("got parts %v, want 3 parts named apart", m.Parts)
+	}
+	for i, part := range m.Parts {
+		if len(part.Filename) > maxFileNameLen || !utf8.ValidString(part.Filename) {
+			t.Errorf("%q: got %d bytes, want at most %d valid UTF-8 bytes", part.Filename, len(part.Filename), maxFileNameLen)
+		}
+		if ext := filepath.Ext(part.Filename); ext != []string{".pdf", ".pdf", ".txt"}[i] {
+			t.Errorf("%q: got extension %q", part.Filename, ext)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, part.Filename))
+		if err != nil || string(data) != fmt.Sprintf("part %d", i+1) {
+			t.Errorf("%q: got %q, %v", part.Filename, data, err)
+		}
+	}
+
+}
+
+// NameFunc names the files of the parts, its names being made safe, the
+// default naming being used when it returns an empty name.
+func TestNameFunc(t *testing.T) {
+
+	var indexes []int
+	opts := Options{NameFunc: func(part *multipart.Part, index int) string {
+		indexes = append(indexes, index)
+		switch part.Header.Get("Content-Type") {
+		case "text/csv":
+			return "../../items.csv"
+		case "text/plain":
+			return ""
+		}
+		sum := sha256.Sum256([]byte(part.FileName()))
+		return hex.EncodeToString(sum[:8]) + ".pdf"
+	}}
+
+	parts, _, err := parseTest(t, strings.NewReader(invoiceMessage), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkParts(t, parts, []testPart{
+		{"XX-1.txt", "Here they are."},
+		{"4186e1167cb2db59.pdf", "first invoice"},
+		{"items.csv", "a,b"},
+		{"d15c3760937ad794.pdf", "second invoice"},
+	})
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(indexes, want) {
+		t.Errorf("got indexes %v, want %v", indexes, want)
+	}
+
+}
@@ -0,0 +1,111 @@
+package mimeparse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// flowedParams tells if the part described by header is a text/plain part
+// with the format=flowed parameter, see RFC 3676, and if its delsp parameter
+// is set.
+func flowedParams(header textproto.MIMEHeader) (flowed, delsp bool) {
+
+	mediaType, params, err := parseContentType(header)
+	if err != nil || mediaType != "text/plain" || !strings.EqualFold(params["format"], "flowed") {
+		return false, false
+	}
+
+	return true, strings.EqualFold(params["delsp"], "yes")
+
+}
+
+// flowedReader unflows the format=flowed text read from r, joining the lines
+// ending with a space, the soft line breaks, to the line following them, as
+// long as it is quoted as deeply. With delsp, the space ending such a line is
+// removed. The space stuffing at the start of the lines is removed too. The
+// charsets whose line endings aren't made of single bytes, such as UTF-16,
+// aren't handled.
+type flowedReader struct {
+	r     *bufio.Reader
+	delsp bool
+
+	// The unflowed text not read yet
+	out bytes.Buffer
+
+	// Set while the lines of a paragraph are joined, along with its quote
+	// depth and the line ending of its last line
+	joining bool
+	depth   int
+	ending  string
+
+	err error
+}
+
+func newFlowedReader(delsp bool, r io.Reader) io.Reader {
+	return &flowedReader{r: bufio.NewReader(r), delsp: delsp}
+}
+
+func (f *flowedReader) Read(p []byte) (int, error) {
+
+	for f.out.Len() == 0 && f.err == nil {
+		line, err := f.r.ReadString('\n')
+		if len(line) > 0 {
+			f.unflow(line)
+		}
+		if err != nil {
+			f.err = err
+			// The last line ending of a paragraph ended by a soft line
+			// break is kept
+			if f.joining {
+				f.out.WriteString(f.ending)
+				f.joining = false
+			}
+		}
+	}
+
+	if f.out.Len() > 0 {
+		return f.out.Read(p)
+	}
+
+	return 0, f.err
+
+}
+
+// unflow adds the line to the unflowed text.
+func (f *flowedReader) unflow(line string) {
+
+	content := strings.TrimRight(line, "\r\n")
+	ending := line[len(content):]
+
+	depth := len(content) - len(strings.TrimLeft(content, ">"))
+	content = strings.TrimPrefix(content[depth:], " ")
+
+	// A line quoted differently ends the paragraph, even after a soft break
+	if f.joining && depth != f.depth {
+		f.out.WriteString(f.ending)
+		f.joining = false
+	}
+
+	if !f.joining {
+		f.out.WriteString(strings.Repeat(">", depth))
+		if depth > 0 {
+			f.out.WriteString(" ")
+		}
+	}
+
+	// The signature separator is always a hard line break
+	soft := strings.HasSuffix(content, " ") && content != "-- " && len(ending) > 0
+	if soft && f.delsp {
+		content = content[:len(content)-1]
+	}
+	f.out.WriteString(content)
+
+	f.joining, f.depth, f.ending = soft, depth, ending
+	if !soft {
+		f.out.WriteString(ending)
+	}
+
+}
@@ -0,0 +1,359 @@
+package mimeparse
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Headers is a parsed representation of the main header fields of a message.
+// The RFC 2047 encoded-words are decoded in all of them. The address fields
+// hold the addresses of all the lines of the field, when it is repeated.
+type Headers struct {
+	From []*mail.Address
+	To   []*mail.Address
+	Cc   []*mail.Address
+
+	// Date is the Date of the message, as parsed by ParseDate, or the zero
+	// time if it can't be parsed.
+	Date time.Time
+
+	Subject string
+
+	// MessageID is the Message-ID of the message, without its angle brackets.
+	MessageID string
+
+	// Received holds the values of the Received fields of the message, the
+	// trace of the servers it went through, in the order they appear: the
+	// first one was added by the last server.
+	Received []string
+
+	// Raw holds all the header fields of the message, as they were read.
+	Raw mail.Header
+}
+
+// parseHeaders builds the Headers of a message from its raw header. The
+// fields which can't be parsed are left empty.
+func parseHeaders(header mail.Header) Headers {
+
+	dec := newWordDecoder()
+	headers := Headers{Raw: header}
+
+	parser := mail.AddressParser{WordDecoder: dec}
+	headers.From = parseAddressLines(parser, header["From"])
+	headers.To = parseAddressLines(parser, header["To"])
+	headers.Cc = parseAddressLines(parser, header["Cc"])
+
+	headers.Date, _ = ParseDate(header.Get("Date"))
+
+	headers.Subject, _ = DecodeHeader(header.Get("Subject"))
+
+	headers.MessageID = strings.Trim(strings.TrimSpace(header.Get("Message-Id")), "<>")
+
+	headers.Received = header["Received"]
+
+	return headers
+
+}
+
+// parseAddressLines parses the addresses of all the lines of an address field,
+// skipping the lines which can't be parsed.
+func parseAddressLines(parser mail.AddressParser, lines []string) []*mail.Address {
+
+	var addresses []*mail.Address
+	for _, line := range lines {
+		if list, err := parser.ParseList(line); err == nil {
+			addresses = append(addresses, list...)
+		}
+	}
+
+	return addresses
+
+}
+
+// Values returns all the values of the header field key, in the order they
+// appear in the message, with their RFC 2047 encoded-words decoded. The case
+// of key doesn't matter.
+func (h Headers) Values(key string) []string {
+
+	var values []string
+	for _, value := range h.Raw[textproto.CanonicalMIMEHeaderKey(key)] {
+		value, _ = DecodeHeader(value)
+		values = append(values, value)
+	}
+
+	return values
+
+}
+
+// dateLayouts are the layouts of the malformed Date values ParseDate
+// handles, once their comments and extra spaces removed. The dates without
+// a time zone are in UTC.
+var dateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04:05",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04",
+	"Mon, 2 Jan 06 15:04:05 -0700",
+	"Mon, 2 Jan 06 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"Mon, 2 January 2006 15:04:05 -0700",
+	"Monday, 2 Jan 2006 15:04:05 -0700",
+	"Monday, 2 January 2006 15:04:05 -0700",
+	"Mon Jan 2 15:04:05 2006",
+	"Mon Jan 2 15:04:05 -0700 2006",
+	"Mon Jan 2 15:04:05 MST 2006",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+}
+
+// ParseDate parses value, the Date of a message, as mail.ParseDate() does,
+// falling back on dateLayouts for the malformed dates often found in real
+// messages, such as dates without a time zone, without seconds, with a full
+// month or day name, or in the ISO 8601 format.
+func ParseDate(value string) (time.Time, error) {
+
+	date, err := mail.ParseDate(value)
+	if err == nil {
+		return date, nil
+	}
+
+	// Drop the comments, such as "(CEST)", a period ending the day name,
+	// and the extra spaces and commas
+	cleaned := value
+	for {
+		start := strings.Index(cleaned, "(")
+		end := strings.Index(cleaned, ")")
+		if start < 0 || end < start {
+			break
+		}
+		cleaned = cleaned[:start] + " " + cleaned[end+1:]
+	}
+	cleaned = strings.ReplaceAll(cleaned, ".,", ",")
+	cleaned = strings.Join(strings.Fields(strings.ReplaceAll(cleaned, ",", ", ")), " ")
+	cleaned = strings.ReplaceAll(cleaned, " ,", ",")
+
+	for _, layout := range dateLayouts {
+		if date, e := time.Parse(layout, cleaned); e == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, err
+
+}
+
+// maxSlugLen is the maximum length, in bytes, of the slugs returned by
+// SubjectSlug.
+const maxSlugLen = 64
+
+// SubjectSlug returns the Subject of the message m, with its RFC 2047
+// encoded-words decoded, as a slug which can be used as a file name on any
+// file system, such as "re-cafe-creme" for "Re: Café crème": the accents are
+// removed, the letters are lowercased, the other characters are replaced with
+// hyphens, and the slug is truncated to 64 bytes. An empty string is returned
+// if there is nothing left.
+func SubjectSlug(m *mail.Message) string {
+
+	subject, _ := DecodeHeader(m.Header.Get("Subject"))
+
+	return slugify(subject)
+
+}
+
+// slugify returns s as a slug, see SubjectSlug.
+func slugify(s string) string {
+
+	// Split the accented letters into their letter and their accents
+	unaccented, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn))), s)
+	if err == nil {
+		s = unaccented
+	}
+
+	var slug strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			hyphen = slug.Len() > 0
+			continue
+		}
+		if hyphen {
+			if slug.Len()+2 > maxSlugLen {
+				break
+			}
+			slug.WriteByte('-')
+			hyphen = false
+		}
+		if slug.Len()+1 > maxSlugLen {
+			break
+		}
+		slug.WriteRune(r)
+	}
+
+	return slug.String()
+
+}
+
+// SenderDateRadix returns the start of the file names given to the parts of a
+// message with Options.SenderDateNames, made of the date of the message, as
+// YYYYMMDD, and of the local part of the address of its sender, as a slug,
+// such as "20230102-john-doe" for a message sent by john.doe@example.com on
+// January 2, 2023. "undated" and "unknown" stand for the date and the sender
+// which can't be parsed.
+func SenderDateRadix(headers Headers) string {
+
+	date := "undated"
+	if !headers.Date.IsZero() {
+		date = headers.Date.Format("20060102")
+	}
+
+	sender := ""
+	if len(headers.From) > 0 {
+		local, _, _ := strings.Cut(headers.From[0].Address, "@")
+		sender = slugify(local)
+	}
+	if len(sender) == 0 {
+		sender = "unknown"
+	}
+
+	return date + "-" + sender
+
+}
+
+// HeadersName is the name of the file written in the output directory with
+// Options.HeadersFile.
+const HeadersName = "headers.txt"
+
+// MaxHeaderLen is the maximum number of bytes of the header of a message
+// recorded for Options.HeadersFile. Only the fields within its start are
+// written beyond.
+const MaxHeaderLen = 1 << 20
+
+// headerRecorder records the header of a message written to it, up to the
+// empty line ending it, ignoring the rest, so the header fields can be
+// written in their order, which mail.Header doesn't keep. At most
+// MaxHeaderLen bytes are recorded, so the whole message isn't held in memory
+// when the empty line is missing.
+type headerRecorder struct {
+	data []byte
+	done bool
+
+	// Start of the last line of data, not ended yet, from which the empty
+	// line is looked for as more data is written, the end of the line being
+	// looked for in the data written since
+	line int
+}
+
+func (h *headerRecorder) Write(p []byte) (int, error) {
+
+	if !h.done {
+		h.data = append(h.data, p...)
+		var end int
+		end, h.line = headerEnd(h.data, h.line, len(h.data)-len(p))
+		switch {
+		case end >= 0:
+			h.data, h.done = h.data[:end], true
+		case len(h.data) > MaxHeaderLen:
+			// Only the complete lines within MaxHeaderLen are kept
+			h.data = h.data[:bytes.LastIndexByte(h.data[:MaxHeaderLen], '\n')+1]
+			h.done = true
+		}
+	}
+
+	return len(p), nil
+
+}
+
+// headerEnd returns the length of the header at the start of data, up to the
+// empty line ending it, or -1 if the empty line isn't found, looking for it
+// from start, the start of a line whose end isn't before from. The start of
+// the last line of data, which isn't ended yet, is also returned.
+func headerEnd(data []byte, start, from int) (int, int) {
+
+	for start < len(data) {
+		end := bytes.IndexByte(data[from:], '\n')
+		if end < 0 {
+			break
+		}
+		end += from
+		if len(bytes.TrimSuffix(data[start:end], []byte("\r"))) == 0 {
+			return start, start
+		}
+		start, from = end+1, end+1
+	}
+
+	return -1, start
+
+}
+
+// formatHeaders returns the header fields of the header data, as recorded by
+// headerRecorder, in their order, one per line: the folded fields are
+// unfolded, and the RFC 2047 encoded-words of their values decoded.
+func formatHeaders(data []byte) []byte {
+
+	// Join the continuation lines to the field they belong to
+	var fields []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if len(fields) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			fields[len(fields)-1] += line
+		} else if len(line) > 0 {
+			fields = append(fields, line)
+		}
+	}
+
+	var b bytes.Buffer
+	for _, field := range fields {
+		if key, value, found := strings.Cut(field, ":"); found {
+			value, _ = DecodeHeader(strings.TrimSpace(value))
+			field = key + ": " + value
+		}
+		b.WriteString(field)
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes()
+
+}
+
+// writeHeaders writes the header fields of the header data, recorded by
+// headerRecorder, as formatted by formatHeaders, to the HeadersName file of
+// the opts.OutputDir directory, or to an entry of opts.Archive, dated date,
+// the date of the message.
+func writeHeaders(data []byte, opts Options, date time.Time) error {
+
+	data = formatHeaders(data)
+
+	if opts.Archive != nil {
+		headers := &stepReader{r: bytes.NewReader(data), step: "reading headers"}
+		_, err := opts.Archive.writeEntry(opts.entryName(HeadersName), headers, -1, opts.fileMode(), entryDate(date))
+		return err
+	}
+
+	filename, err := opts.outputPath(HeadersName)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(opts.fileSystem(), filename, data, opts.fileMode()); err != nil {
+		return fmt.Errorf("writing headers to %q: %w", filename, err)
+	}
+
+	return nil
+
+}
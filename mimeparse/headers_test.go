@@ -0,0 +1,204 @@
+package mimeparse
+
+import (
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The malformed dates often found in real messages are parsed all the same.
+func TestParseDate(t *testing.T) {
+
+	paris := time.FixedZone("", 3600)
+	want := time.Date(2023, 1, 2, 10, 4, 5, 0, paris)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"Mon, 2 Jan 2023 10:04:05 +0100", want},
+		{"Mon, 02 Jan 2023 10:04:05 +0100 (CET)", want},
+		{"Mon,  2 Jan 2023  10:04:05  +0100", want},
+		{"Mon., 2 Jan 2023 10:04:05 +0100", want},
+		{"Mon,2 Jan 2023 10:04:05 +0100", want},
+		{"Monday, 2 January 2023 10:04:05 +0100", want},
+		{"2 Jan 2023 10:04:05 +0100", want},
+		{"Mon, 2 Jan 23 10:04:05 +0100", want},
+		{"Mon Jan 2 10:04:05 +0100 2023", want},
+		{"2023-01-02T10:04:05+01:00", want},
+		{"2023-01-02 10:04:05 +0100", want},
+		{"Mon, 2 Jan 2023 10:04:05", time.Date(2023, 1, 2, 10, 4, 5, 0, time.UTC)},
+		{"Mon, 2 Jan 2023 10:04 +0100", time.Date(2023, 1, 2, 10, 4, 0, 0, paris)},
+		{"Mon Jan 2 10:04:05 2023", time.Date(2023, 1, 2, 10, 4, 5, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		date, err := ParseDate(test.value)
+		if err != nil || !date.Equal(test.want) {
+			t.Errorf("%q: got %v, %v, want %v", test.value, date, err, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "yesterday", "32 Jan 2023 10:04:05 +0100"} {
+		if date, err := ParseDate(value); err == nil {
+			t.Errorf("%q: got %v, want an error", value, date)
+		}
+	}
+
+	m, err := ParseEmail(strings.NewReader("Date: Monday, 2 January 2023 10:04:05 +0100\r\n"+invoiceMessage), Options{DryRun: true})
+	if err != nil || !m.Headers.Date.Equal(want) {
+		t.Errorf("got Date %v, %v, want %v", m.Headers.Date, err, want)
+	}
+
+}
+
+func TestSubjectSlug(t *testing.T) {
+
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"=?UTF-8?Q?Re:_Caf=C3=A9_cr=C3=A8me?=", "re-cafe-creme"},
+		{"=?ISO-8859-1?B?UukgOiDpdOkgMjAyMw==?=", "re-ete-2023"},
+		{"  Invoice #42 / Q1!  ", "invoice-42-q1"},
+		{"../../etc/passwd", "etc-passwd"},
+		{"日本語", ""},
+		{"", ""},
+		{strings.Repeat("word ", 30), strings.TrimSuffix(strings.Repeat("word-", 13), "-")},
+	}
+
+	for _, test := range tests {
+		m := &mail.Message{Header: mail.Header{"Subject": {test.subject}}}
+		if got := SubjectSlug(m); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.subject, got, test.want)
+		}
+	}
+
+	// With SubjectDir, the parts are written to a directory named after the
+	// subject of the message
+	dir := t.TempDir()
+	message := "Subject: =?UTF-8?Q?Caf=C3=A9?=\r\n" + invoiceMessage
+	if _, err := ParseEmail(strings.NewReader(message), Options{OutputDir: dir, SubjectDir: true, Verbosity: Quiet}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cafe", "invoice.pdf")); err != nil {
+		t.Error(err)
+	}
+
+}
+
+// With HeadersFile, the header fields are written in their order, unfolded
+// and decoded.
+func TestHeadersFile(t *testing.T) {
+
+	message := "From: =?UTF-8?Q?Ren=C3=A9e?= <renee@example.com>\r\n" +
+		"To: bob@example.com,\r\n\tcarol@example.com\r\n" +
+		"Subject: =?UTF-8?B?Q2Fmw6k=?=\r\n" +
+		"Received: from a\r\nReceived: from b\r\n" +
+		"Content-Type: text/plain\r\n\r\nHello\r\n"
+
+	dir := t.TempDir()
+	if _, err := ParseEmail(strings.NewReader(message), Options{OutputDir: dir, HeadersFile: true, Verbosity: Quiet}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, HeadersName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "From: Renée <renee@example.com>\n" +
+		"To: bob@example.com,\tcarol@example.com\n" +
+		"Subject: Café\n" +
+		"Received: from a\nReceived: from b\n" +
+		"Content-Type: text/plain\n"
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+
+}
+
+// The header is recorded up to the empty line ending it, whatever the writes
+// it is split across, and up to MaxHeaderLen bytes when the line is missing.
+func TestHeaderRecorder(t *testing.T) {
+
+	header := "From: alice@example.com\r\nSubject: Hello\r\n"
+	message := header + "\r\nHello\r\n\r\nWorld\r\n"
+
+	for _, size := range []int{1, 2, 3, 7, len(message)} {
+		recorder := &headerRecorder{}
+		for data := message; len(data) > 0; {
+			n := size
+			if n > len(data) {
+				n = len(data)
+			}
+			recorder.Write([]byte(data[:n]))
+			data = data[n:]
+		}
+		if string(recorder.data) != header || !recorder.done {
+			t.Errorf("writes of %d bytes: got %q, want %q", size, recorder.data, header)
+		}
+	}
+
+	recorder := &headerRecorder{}
+	line := []byte("X-Filler: " + strings.Repeat("x", 100) + "\r\n")
+	for i := 0; i < 2*MaxHeaderLen/len(line) && !recorder.done; i++ {
+		recorder.Write(line)
+	}
+	if !recorder.done || len(recorder.data) > MaxHeaderLen || len(recorder.data)%len(line) != 0 {
+		t.Errorf("got %d bytes, done %v, want at most %d bytes of complete lines", len(recorder.data), recorder.done, MaxHeaderLen)
+	}
+	recorder.Write(line)
+	if len(recorder.data) > MaxHeaderLen {
+		t.Errorf("got %d bytes once done, want at most %d", len(recorder.data), MaxHeaderLen)
+	}
+
+	// A line longer than MaxHeaderLen, written a byte at a time, isn't
+	// looked through again at each write
+	recorder = &headerRecorder{}
+	for i := 0; i <= MaxHeaderLen; i++ {
+		recorder.Write([]byte{'x'})
+	}
+	if !recorder.done || len(recorder.data) != 0 {
+		t.Errorf("got %d bytes, done %v, want none", len(recorder.data), recorder.done)
+	}
+
+}
+
+// The adjacent encoded-words are decoded as a whole, the whitespace between
+// them being removed.
+func TestDecodeHeader(t *testing.T) {
+
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"=?UTF-8?B?Q2Fmww==?= =?UTF-8?B?qSBjcsOobWU=?=", "Café crème", false},
+		{"=?utf-8?b?5pel5g==?=\r\n =?UTF-8?B?nKzoqp7jga7ku7blkI0=?=", "日本語の件名", false},
+		{"=?UTF-8?Q?Caf=C3?=\t=?UTF-8?Q?=A9?=", "Café", false},
+		{"=?UTF-8?B?Q2Fmw6k?=", "Café", false},
+		{"Re: =?UTF-8?Q?Caf=C3=A9?= and =?UTF-8?Q?cr=C3=A8me?=", "Re: Café and crème", false},
+		{"=?ISO-8859-1?Q?Caf=E9?= =?UTF-8?Q?_cr=C3=A8me?=", "Café crème", false},
+		{"=?utf-8*fr?Q?Caf=C3=A9?=", "Café", false},
+		{"no encoded word", "no encoded word", false},
+		{"=?x-unknown?Q?abc?= =?UTF-8?Q?d?=", "=?x-unknown?Q?abc?=d", true},
+		{"=?UTF-8?B?!!!?=", "=?UTF-8?B?!!!?=", true},
+	}
+
+	for _, test := range tests {
+		got, err := DecodeHeader(test.value)
+		if got != test.want || (err != nil) != test.err {
+			t.Errorf("%q: got %q, %v, want %q", test.value, got, err, test.want)
+		}
+	}
+
+	// The Subject of the message is decoded alike
+	m, err := ParseEmail(strings.NewReader("Subject: "+tests[0].value+"\r\n"+invoiceMessage), Options{DryRun: true})
+	if err != nil || m.Subject != tests[0].want {
+		t.Errorf("got Subject %q, %v, want %q", m.Subject, err, tests[0].want)
+	}
+
+}
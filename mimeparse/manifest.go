@@ -0,0 +1,42 @@
+package mimeparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ManifestName is the name of the file written in the output directory with
+// Options.Manifest.
+const ManifestName = "manifest.json"
+
+// writeManifest writes the description of parts, as JSON, to the ManifestName
+// file of the opts.OutputDir directory, or to an entry of opts.Archive, dated
+// date, the date of the message.
+func writeManifest(parts []PartMeta, opts Options, date time.Time) error {
+
+	data, err := json.MarshalIndent(parts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+	data = append(data, '\n')
+
+	if opts.Archive != nil {
+		manifest := &stepReader{r: bytes.NewReader(data), step: "reading manifest"}
+		_, err := opts.Archive.writeEntry(opts.entryName(ManifestName), manifest, -1, opts.fileMode(), entryDate(date))
+		return err
+	}
+
+	filename, err := opts.outputPath(ManifestName)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(opts.fileSystem(), filename, data, opts.fileMode()); err != nil {
+		return fmt.Errorf("writing manifest to %q: %w", filename, err)
+	}
+
+	return nil
+
+}
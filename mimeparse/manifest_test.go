@@ -0,0 +1,47 @@
+package mimeparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The manifest describes every part, in the order of the tree, as returned
+// in Message.Parts.
+func TestManifest(t *testing.T) {
+
+	dir := t.TempDir()
+	m, err := ParseEmail(bytes.NewReader(readFixture(t, "nested.eml")), Options{OutputDir: dir, Manifest: true, ParseAttachedMessages: true, Verbosity: Quiet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []PartMeta
+	if err := json.Unmarshal(data, &parts); err != nil {
+		t.Fatalf("decoding manifest: %v", err)
+	}
+	if !reflect.DeepEqual(parts, m.Parts) {
+		t.Errorf("got manifest %+v, want %+v", parts, m.Parts)
+	}
+
+	var positions []string
+	for _, part := range parts {
+		positions = append(positions, part.Position+" "+part.Filename)
+	}
+	if want := []string{"1.1 inner-1.txt", "1.2 inner-2.html", "2.1 message-2.txt"}; !reflect.DeepEqual(positions, want) {
+		t.Errorf("got parts %q, want %q", positions, want)
+	}
+	for _, part := range parts {
+		if part.ContentType == "" || part.Written == 0 || part.Size != -1 || len(part.SHA256) != 64 {
+			t.Errorf("%s: incomplete description %+v", part.Filename, part)
+		}
+	}
+
+}
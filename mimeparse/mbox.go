@@ -0,0 +1,153 @@
+package mimeparse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+)
+
+// MboxReader reads the messages of a mailbox in the mbox format, where each
+// message starts with a "From " line. The lines of the messages starting with
+// "From ", preceded by any number of '>', were escaped with one more '>' when
+// the message was written to the mailbox: they are unescaped as they are read.
+type MboxReader struct {
+	r       *bufio.Reader
+	current *mboxMessage
+}
+
+// NewMboxReader returns a MboxReader reading the mailbox from r.
+func NewMboxReader(r io.Reader) *MboxReader {
+	return &MboxReader{r: bufio.NewReader(r)}
+}
+
+// Next returns a reader over the next message of the mailbox, without its
+// "From " line, or io.EOF if there is no more message. The message is read
+// from the mailbox as it is read from the reader returned, which can't be
+// used anymore once Next is called again.
+func (m *MboxReader) Next() (io.Reader, error) {
+
+	// Skip what is left of the current message
+	if m.current != nil {
+		if _, err := io.Copy(io.Discard, m.current); err != nil {
+			return nil, err
+		}
+		m.current = nil
+	}
+
+	// Skip anything up to the "From " line of the next message
+	for {
+		line, err := m.r.ReadBytes('\n')
+		if bytes.HasPrefix(line, []byte("From ")) {
+			break
+		}
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.current = &mboxMessage{r: m.r}
+	return m.current, nil
+
+}
+
+// mboxMessage reads a message from a mailbox, up to the "From " line of the
+// next message.
+type mboxMessage struct {
+	r    *bufio.Reader
+	line []byte
+	err  error
+}
+
+func (m *mboxMessage) Read(p []byte) (int, error) {
+
+	for len(m.line) == 0 {
+
+		if m.err != nil {
+			return 0, m.err
+		}
+
+		// The message ends where the next one starts
+		if next, _ := m.r.Peek(5); bytes.Equal(next, []byte("From ")) {
+			m.err = io.EOF
+			continue
+		}
+
+		m.line, m.err = m.r.ReadBytes('\n')
+		m.line = unescapeFrom(m.line)
+
+	}
+
+	n := copy(p, m.line)
+	m.line = m.line[n:]
+
+	return n, nil
+
+}
+
+// unescapeFrom removes the '>' added in front of a line starting with "From ",
+// itself preceded by any number of '>', when the message was written to the
+// mailbox. Other lines are returned as is.
+func unescapeFrom(line []byte) []byte {
+
+	quoted := bytes.TrimLeft(line, ">")
+	if len(quoted) < len(line) && bytes.HasPrefix(quoted, []byte("From ")) {
+		return line[1:]
+	}
+
+	return line
+
+}
+
+// ParseMbox reads a mailbox in the mbox format from r, and parses each of its
+// messages with ParseEmail. The MIME parts of each message are written in a
+// subdirectory of opts.OutputDir named after the position of the message in
+// the mailbox: "1", "2", ... The messages are returned in the same order,
+// along with all the errors met, so a message that can't be parsed doesn't
+// prevent the parsing of the next ones. With opts.Events, the messages are
+// described by the events rather than returned. An error wrapping
+// ErrNoMessages is returned if there is no message at all.
+func ParseMbox(r io.Reader, opts Options) ([]*Message, error) {
+
+	var messages []*Message
+	var errs []error
+
+	mbox := NewMboxReader(r)
+	for i := 1; ; i++ {
+
+		data, err := mbox.Next()
+		if err == io.EOF && i == 1 {
+			errs = append(errs, fmt.Errorf("reading mbox: %w", ErrNoMessages))
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading mbox: %w", err))
+			break
+		}
+
+		message_opts := opts
+		message_opts.OutputDir = filepath.Join(opts.OutputDir, strconv.Itoa(i))
+		message_opts.mboxIndex = i
+
+		m, err := ParseEmail(data, message_opts)
+		if m != nil && opts.Events == nil {
+			messages = append(messages, m)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("message %d: %w", i, err))
+		}
+
+	}
+
+	return messages, errors.Join(errs...)
+
+}
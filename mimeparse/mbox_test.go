@@ -0,0 +1,94 @@
+package mimeparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMbox(t *testing.T) {
+
+	mbox := "From alice@example.com Mon Jan  2 10:00:00 2023\r\n" +
+		"From: alice@example.com\r\nSubject: First\r\n\r\nHello\r\n>From the start\r\n\r\n" +
+		"From bob@example.com Mon Jan  2 11:00:00 2023\r\n" +
+		"From: bob@example.com\r\nSubject: Second\r\n\r\nBye\r\n"
+
+	var bodies []string
+	messages, err := ParseMbox(strings.NewReader(mbox), Options{OnPart: func(meta PartMeta, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		bodies = append(bodies, string(data))
+		return err
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Subject != "First" || messages[1].Subject != "Second" {
+		t.Fatalf("got %d messages, want First and Second", len(messages))
+	}
+	if len(bodies) != 2 || bodies[0] != "Hello\r\nFrom the start\r\n\r\n" || bodies[1] != "Bye\r\n" {
+		t.Errorf("got bodies %q", bodies)
+	}
+
+}
+
+// Data without any "From " line isn't a mailbox.
+func TestParseMboxNoMessages(t *testing.T) {
+
+	for _, data := range []string{"", "From: alice@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"} {
+		messages, err := ParseMbox(strings.NewReader(data), Options{DryRun: true})
+		if len(messages) != 0 || !errors.Is(err, ErrNoMessages) {
+			t.Errorf("%q: got %d messages, %v, want ErrNoMessages", data, len(messages), err)
+		}
+	}
+
+}
+
+// With Events, each part and each message is described by a line of JSON, the
+// messages of the mailbox not being kept.
+func TestEvents(t *testing.T) {
+
+	mbox := "From alice@example.com Mon Jan  2 10:00:00 2023\r\n" + invoiceMessage +
+		"From bob@example.com Mon Jan  2 11:00:00 2023\r\n" +
+		"From: bob@example.com\r\nSubject: Second\r\nContent-Type: multipart/mixed\r\n\r\nno boundary\r\n"
+
+	var events bytes.Buffer
+	messages, err := ParseMbox(strings.NewReader(mbox), Options{DryRun: true, Events: &events})
+	if !errors.Is(err, ErrNoBoundary) {
+		t.Errorf("got error %v, want the ErrNoBoundary of the second message", err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("got %d messages, want none", len(messages))
+	}
+
+	var got []string
+	for _, line := range strings.SplitAfter(events.String(), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		var event Event
+		if !strings.HasSuffix(line, "\n") || json.Unmarshal([]byte(line), &event) != nil {
+			t.Fatalf("invalid line %q", line)
+		}
+		switch event.Type {
+		case PartEvent:
+			got = append(got, fmt.Sprintf("%d part %s", event.Message, event.Part.Filename))
+		case MessageEvent:
+			got = append(got, fmt.Sprintf("%d message %s %d %t", event.Message, event.From, event.Parts, len(event.Error) > 0))
+		}
+	}
+
+	want := []string{
+		"1 part XX-1.txt", "1 part invoice.pdf", "1 part items.csv", "1 part Invoice (1).PDF",
+		"1 message alice@example.com 4 false",
+		"2 message bob@example.com 0 true",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+
+}
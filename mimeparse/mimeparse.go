@@ -0,0 +1,954 @@
+// Package mimeparse reads a MIME email and explodes its MIME parts into
+// separated files, one for each part, named upon the type of the part.
+package mimeparse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options controls how ParseEmail processes a message.
+type Options struct {
+
+	// DryRun walks through the MIME parts of the message without writing
+	// any of them to disk. The parts are decoded all the same, so the parts
+	// and errors returned are the ones of a normal run.
+	DryRun bool
+
+	// OutputDir is the directory where the MIME parts are written. It is
+	// created if it doesn't exist. An empty OutputDir means the current
+	// working directory.
+	OutputDir string
+
+	// Archive, if set, receives the MIME parts as entries, instead of them
+	// being written to separate files. The entries are named as the files
+	// would be, OutputDir being their directory within the archive, and no
+	// directory is created. The archive isn't closed by the parsing. See
+	// NewZipArchive and NewTarArchive.
+	Archive *Archive
+
+	// FS, if set, is the file system the files are written to, such as an
+	// in-memory file system, rather than the one of the operating system.
+	// The output directory, OutputDir, is the one of FS.
+	FS FS
+
+	// FileMode and DirMode are the permissions of the files written and of
+	// the output directory when it is created, DefaultFileMode and
+	// DefaultDirMode if zero. When set, they are applied whatever the umask.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// PositionalNames prefixes the name of the file of each part with its
+	// position in the tree of the MIME parts, see PartMeta.Position, such as
+	// "1.2-photo.jpg", so the names are unique and show the structure of the
+	// message, all the files being in the same directory.
+	PositionalNames bool
+
+	// PortableNames makes the file names valid on Windows too, whatever the
+	// OS: the characters Windows forbids, such as ':' or '?', are replaced
+	// with '_', and the names reserved for devices, such as "CON" or "COM1",
+	// are suffixed with '_'. It is always done on Windows.
+	PortableNames bool
+
+	// SubjectDir writes the parts of the message to a subdirectory of
+	// OutputDir named after its Subject, see SubjectSlug, or "no-subject"
+	// when it has none.
+	SubjectDir bool
+
+	// TempDir writes the parts of the message to a new directory with a
+	// unique name, created in OutputDir, or in the default directory for
+	// temporary files, os.TempDir(), if OutputDir isn't set. The directory is
+	// given by Message.Dir, to be removed by the caller once the parts are
+	// used. Its permissions are 0700, unless DirMode is set. It isn't created
+	// in a dry run, nor with Archive, OnPart or FS.
+	TempDir bool
+
+	// Extensions maps media types, such as "image/jpeg", to the extension,
+	// such as ".jpg", of the files written for the parts of this type which
+	// have no file name of their own. It takes precedence over the extensions
+	// chosen by default. The media types are expected in lower case.
+	Extensions map[string]string
+
+	// NameFunc, if set, names the files of the parts instead of
+	// BuildFileName, given the part and its position at its level, from 1.
+	// Only the Header of part is meaningful: its data mustn't be read, as it
+	// is read afterwards to be written. The name returned is made safe as
+	// the names given by the messages are. The default naming is used if it
+	// is empty. The other options on the names, such as PositionalNames,
+	// still apply.
+	NameFunc func(part *multipart.Part, index int) string
+
+	// SenderDateNames names the files of the parts after the date and the
+	// sender of the message, and the number of the part in the message, from
+	// 1, such as "20230102-john-doe-2.pdf", see SenderDateRadix, so the files
+	// of many messages can be kept together. Only the extension of the file
+	// names given by the message is kept. NameFunc takes precedence.
+	SenderDateNames bool
+
+	// FailFast stops the extraction at the first part which can't be
+	// extracted. Otherwise, the extraction goes on with the next parts, as
+	// far as possible, and all the errors are returned together. Either way,
+	// the error of each part is also given by PartMeta.Error. With Workers,
+	// the parts being written by the other workers when a part fails are
+	// abandoned, failing with context.Canceled.
+	FailFast bool
+
+	// Boundary, if set, is the boundary of the MIME parts of the message,
+	// used whatever its Content-Type, such as a message whose Content-Type is
+	// malformed, or lacks its boundary, while the boundary is known by other
+	// means. The message is then always parsed as multipart, as
+	// multipart/mixed unless its Content-Type says otherwise. The parts of
+	// the message, and of the attached messages, keep their own boundaries.
+	Boundary string
+
+	// MaxDepth is the maximum number of nested multipart levels parsed,
+	// DefaultMaxDepth if zero. The MIME parts nested deeper are skipped
+	// with an error, as a protection against crafted messages.
+	MaxDepth int
+
+	// MaxTotalBytes is the maximum number of bytes written for all the parts
+	// of the message, unlimited if zero. The extraction stops with an error as
+	// soon as it is exceeded.
+	MaxTotalBytes int64
+
+	// MaxPartSize is the maximum number of bytes written for a single part,
+	// unlimited if zero. A bigger part isn't written, and an error is returned
+	// for it, but the extraction goes on with the next parts.
+	MaxPartSize int64
+
+	// MaxParts is the maximum number of parts extracted from the message,
+	// unlimited if zero, counting the parts of all the levels which aren't
+	// multipart, whether they are written or skipped. The extraction stops
+	// with an error wrapping ErrTooManyParts at the next part.
+	MaxParts int
+
+	// PartTimeout is the maximum time spent reading and decoding a single
+	// part, unlimited if zero, such as to bound the time spent on a crafted
+	// or stalled input. It covers every read of the part, from its header to
+	// its end, even when it is sniffed, or skipped. A part exceeding it is
+	// abandoned, failing with an error wrapping ErrPartTimeout, and the
+	// extraction of the message stops, as the rest of the part can't be told
+	// apart from the next parts anymore.
+	PartTimeout time.Duration
+
+	// Workers is the number of MIME parts decoded and written at the same
+	// time, one at a time if it is zero or one. With several workers, each
+	// part is read in memory before being handed to one of them. The parts
+	// are named in the order they are read all the same, but OnPart may be
+	// called concurrently, and MaxTotalBytes only accounts for the parts
+	// already written when a part is started.
+	Workers int
+
+	// MaxBytesPerPart is the maximum number of bytes written for a single
+	// part, unlimited if zero. Unlike with MaxPartSize, a bigger part is
+	// written all the same, but truncated, see PartMeta.Truncated, such as
+	// for a preview of the part.
+	MaxBytesPerPart int64
+
+	// NoDecode writes the data of the parts as received, without decoding it
+	// from its Content-Transfer-Encoding, such as to keep the evidence of
+	// what was received. The files of the base64 and quoted-printable parts
+	// are named with a ".base64" or ".quoted-printable" suffix, such as
+	// "photo.jpg.base64". The data isn't converted either, whatever
+	// ConvertCharset, NewLine, Unflow or SanitizeHTML, and it is what
+	// TextBody, HTMLBody and Attachments return. See also WriteRaw.
+	NoDecode bool
+
+	// ConvertCharset converts the text/* parts from the charset declared
+	// in their Content-Type to UTF-8 before they are written, once decoded
+	// from their Content-Transfer-Encoding, such as the 8bit parts, whose
+	// data is made of the raw bytes of their charset. The parts in an
+	// unknown charset are written as is.
+	ConvertCharset bool
+
+	// DefaultCharset is the charset of the text/* parts whose Content-Type
+	// has no charset parameter, such as "iso-8859-1" or "utf-8" for the
+	// sloppy mailers which don't declare it, rather than US-ASCII, as RFC 2045
+	// says. It is used for TextBody, HTMLBody and ConvertCharset, and given by
+	// PartMeta.Charset.
+	DefaultCharset string
+
+	// NewLine, if set, converts the line endings of the text/* parts, CRLF or
+	// LF, to NewLine, such as "\n". The other parts are never converted.
+	NewLine string
+
+	// Unflow joins the soft-wrapped lines of the text/plain parts sent with
+	// the format=flowed parameter, see RFC 3676, so each paragraph is written
+	// as a single line. The other parts are written as they are.
+	Unflow bool
+
+	// SanitizeHTML removes from the text/html parts what could run code, or
+	// load remote content, so they can be displayed safely: the script,
+	// iframe, object and link elements among others, the on* event handlers,
+	// the src, href and such attributes with a remote URL, the styles loading
+	// URLs, and the comments.
+	// The references to the other parts of the message ("cid:" URLs) are
+	// kept. The other parts are written as they are.
+	SanitizeHTML bool
+
+	// HTMLTextFallback makes Message.TextBody return the text of the
+	// text/html body of a message without a text/plain body, such as a
+	// message only made of HTML, with its tags removed, see HTMLText. The
+	// message must be parsed with KeepTextBody.
+	HTMLTextFallback bool
+
+	// KeepTextBody keeps the decoded text of the body of the message, its
+	// text/plain and text/html parts, in memory as it is written, for
+	// Message.TextBody() and Message.HTMLBody(). As nothing is written, the
+	// text isn't kept with DryRun, nor for the parts skipped.
+	KeepTextBody bool
+
+	// KeepPreamble keeps the preamble and the epilogue of a multipart
+	// message, the data before its first boundary and after its closing one,
+	// which are otherwise discarded, in Message.Preamble and Message.Epilogue.
+	// The epilogue is read up to the end of the message. At most
+	// MaxPreambleLen bytes are kept for each. Those of the nested multipart
+	// parts aren't kept.
+	KeepPreamble bool
+
+	// ParseAttachedMessages parses the messages attached to the message, the
+	// message/rfc822 parts, so their own MIME parts are extracted. Otherwise
+	// they are extracted as a whole, as any other attachment.
+	ParseAttachedMessages bool
+
+	// SniffMessages parses the application/octet-stream parts whose decoded
+	// data starts with the header of a multipart message, with a boundary,
+	// as attached messages, whatever ParseAttachedMessages, so the parts of a
+	// forwarded message mislabeled by a broken mailer are extracted. The
+	// header of the message must be found in the first 8 KB of the part.
+	SniffMessages bool
+
+	// AttachmentsOnly only writes the parts which are attachments, skipping
+	// the inline ones, such as the body of the message. See PartMeta.Disposition.
+	AttachmentsOnly bool
+
+	// WriteRaw also writes the raw data of each part, as found in the message
+	// before it is decoded, to a file named after the file of the part with a
+	// RawSuffix, such as "photo.jpg.raw", even when it can't be decoded, such
+	// as with an unknown Content-Transfer-Encoding. It is ignored with Archive
+	// and OnPart.
+	WriteRaw bool
+
+	// SkipSignatures skips the parts holding the cryptographic signature of
+	// the message, such as application/pgp-signature or application/pkcs7-signature,
+	// the signed content being extracted as usual. See Message.Signed.
+	SkipSignatures bool
+
+	// SkipEncrypted skips the encrypted parts, PGP/MIME or S/MIME, which
+	// can't be used without the keys to decrypt them. See Message.Encrypted.
+	SkipEncrypted bool
+
+	// KeepAttachments keeps the decoded data of the attachments in memory, as
+	// they are written, for Message.Attachments(). Along with DryRun, the
+	// attachments can be extracted without writing anything to disk.
+	KeepAttachments bool
+
+	// IgnoreNameCase makes Message.AttachmentByName match the names given
+	// to the attachments whatever their case, such as "Invoice.PDF" for
+	// "invoice.pdf".
+	IgnoreNameCase bool
+
+	// IncludeTypes and ExcludeTypes filter the parts written upon their media
+	// type, with patterns such as "application/pdf" or "image/*", as matched by
+	// path.Match(), whatever the case. With IncludeTypes, only the parts matching
+	// one of its patterns are written, and the parts matching one of the patterns
+	// of ExcludeTypes are never written. The parts filtered out are skipped,
+	// see PartMeta.Skipped, but the multipart parts are always gone through.
+	IncludeTypes []string
+	ExcludeTypes []string
+
+	// IncludeNames filters the parts written upon the name of their file,
+	// with patterns such as "*.pdf" or "report-*.xlsx", as matched by
+	// path.Match(), whatever the case. Only the parts matching one of its
+	// patterns are written. The name matched is the one given by the message,
+	// once decoded and made safe, or else the one built for the part, without
+	// the prefix of PositionalNames, the encoding suffix of NoDecode, or the
+	// suffix added to tell apart the parts of the same name.
+	//
+	// When both IncludeTypes and IncludeNames are set, a part is written if it
+	// matches both, or with MatchAny, if it matches either of them.
+	// ExcludeTypes applies in any case.
+	IncludeNames []string
+	MatchAny     bool
+
+	// LenientBase64 drops the characters which aren't base64 found in the
+	// data of the base64 parts, such as stray bytes left by a broken relay,
+	// rather than failing, so the rest of the data is still decoded. The
+	// padding found before the end of the data is dropped too. The number of
+	// characters dropped is given by PartMeta.Base64Dropped.
+	LenientBase64 bool
+
+	// PreserveDate sets the modification time of the files written to the
+	// Date of the message, when it has a valid one.
+	PreserveDate bool
+
+	// Manifest writes a ManifestName file in the output directory, with the
+	// description of all the parts of the message, as JSON.
+	Manifest bool
+
+	// HeadersFile writes a HeadersName file in the output directory, with all
+	// the header fields of the message, in their order, one per line: the
+	// folded fields are unfolded, and their RFC 2047 encoded-words decoded.
+	// Only the fields within the first MaxHeaderLen bytes are written.
+	HeadersFile bool
+
+	// OnPart, if set, is called for each MIME part extracted instead of
+	// writing it to a file, with a reader over its decoded data, so the caller
+	// can store or process the part as it sees fit. The data not read by OnPart
+	// is discarded, and PartMeta.Written and PartMeta.SHA256 only cover what was
+	// read. An error returned by OnPart is returned for the part, but doesn't stop
+	// the extraction of the next ones. OnPart isn't called with DryRun, nor for
+	// the parts skipped.
+	OnPart func(meta PartMeta, r io.Reader) error
+
+	// Events, if set, receives the description of each message, and of each
+	// of its MIME parts, as newline-delimited JSON, one Event per line, as
+	// soon as the message is parsed, such as for a log ingestion pipeline.
+	// With Events, ParseMbox doesn't keep the messages it parses, so the
+	// memory used doesn't grow with the size of the mailbox.
+	Events io.Writer
+
+	// Position of the message in its mailbox with ParseMbox, for Events
+	mboxIndex int
+
+	// Logger receives the diagnostics of the parsing, as much as Verbosity
+	// allows. Nothing is written if it is nil.
+	Logger *log.Logger
+
+	// Verbosity is the level of the diagnostics written to Logger, Normal by
+	// default.
+	Verbosity Verbosity
+}
+
+// Verbosity is the level of the diagnostics of the parsing, see Options.Verbosity.
+type Verbosity int
+
+const (
+	// Quiet writes no diagnostics at all, the errors being only returned.
+	Quiet Verbosity = -1
+
+	// Normal writes a line for each file written, with its type and size.
+	Normal Verbosity = 0
+
+	// Verbose writes the same as Normal. It used to also write the tree of
+	// the MIME parts.
+	//
+	// Deprecated: the tree of the MIME parts is given as data by
+	// ParseStructure. Use Normal.
+	Verbose Verbosity = 1
+)
+
+// DefaultMaxDepth is the maximum number of nested multipart levels parsed
+// when Options.MaxDepth isn't set.
+const DefaultMaxDepth = 50
+
+// portableNames tells if the file names have to be valid on Windows.
+func (opts Options) portableNames() bool {
+	return opts.PortableNames || runtime.GOOS == "windows"
+}
+
+// maxDepth returns the maximum number of nested multipart levels to parse.
+func (opts Options) maxDepth() int {
+
+	if opts.MaxDepth > 0 {
+		return opts.MaxDepth
+	}
+
+	return DefaultMaxDepth
+
+}
+
+// DefaultFileMode and DefaultDirMode are the permissions of the files and of
+// the output directory created when Options.FileMode and Options.DirMode
+// aren't set. They are subject to the umask of the process.
+const (
+	DefaultFileMode os.FileMode = 0644
+	DefaultDirMode  os.FileMode = 0755
+)
+
+// fileMode returns the permissions of the files written.
+func (opts Options) fileMode() os.FileMode {
+
+	if opts.FileMode != 0 {
+		return opts.FileMode
+	}
+
+	return DefaultFileMode
+
+}
+
+// dirMode returns the permissions of the output directory, when it is created.
+func (opts Options) dirMode() os.FileMode {
+
+	if opts.DirMode != 0 {
+		return opts.DirMode
+	}
+
+	return DefaultDirMode
+
+}
+
+// outputPath returns the path of filename in the output directory, making
+// sure the directory exists beforehand in the FS the files are written to.
+func (opts Options) outputPath(filename string) (string, error) {
+
+	if len(opts.OutputDir) == 0 {
+		return filename, nil
+	}
+
+	fsys := opts.fileSystem()
+	_, err := fsys.Stat(opts.OutputDir)
+	if os.IsNotExist(err) {
+		if err := fsys.MkdirAll(opts.OutputDir, opts.dirMode()); err != nil {
+			return "", fmt.Errorf("creating output directory %q: %w", opts.OutputDir, err)
+		}
+		// Set the permissions asked for whatever the umask
+		if opts.DirMode != 0 {
+			if err := fsys.Chmod(opts.OutputDir, opts.DirMode); err != nil {
+				return "", fmt.Errorf("setting the permissions of %q: %w", opts.OutputDir, err)
+			}
+		}
+	}
+
+	return filepath.Join(opts.OutputDir, filename), nil
+
+}
+
+// Message is the result of parsing an email with ParseEmail.
+type Message struct {
+
+	// Header is the raw header of the message, as read by mail.ReadMessage().
+	Header mail.Header
+
+	// Headers is the parsed representation of the main header fields.
+	Headers Headers
+
+	// Main header fields of the message. From, To and Subject are decoded
+	// if they were encoded using RFC 2047. From and To join the values of all
+	// the lines of the field, when it is repeated.
+	From        string
+	To          string
+	Date        string
+	Subject     string
+	ContentType string
+
+	// Parts describes each of the MIME parts extracted from the message,
+	// in the order they were read.
+	Parts []PartMeta
+
+	// TotalParts is the number of parts in Parts, of which AttachmentCount
+	// are attachments and InlineCount are inline, see PartMeta.Disposition.
+	// TotalBytesWritten is the number of bytes written for all the parts.
+	TotalParts        int
+	AttachmentCount   int
+	InlineCount       int
+	TotalBytesWritten int64
+
+	// Signed is true if the message has a signature part, PGP/MIME or S/MIME,
+	// whether it is skipped with Options.SkipSignatures or not. The signature
+	// isn't verified.
+	Signed bool
+
+	// Encrypted is true if the message has encrypted parts, the parts of a
+	// multipart/encrypted (PGP/MIME), or an application/pkcs7-mime part
+	// holding enveloped data (S/MIME). They are extracted as is, undecrypted,
+	// unless Options.SkipEncrypted is set.
+	Encrypted bool
+
+	// ContentIDs maps the Content-ID of the parts which have one, without
+	// its angle brackets, to the name of the file the part is written to.
+	// It allows to rewrite the "cid:" URLs of an HTML body, referencing its
+	// embedded images, to the extracted files.
+	ContentIDs map[string]string
+
+	// ContentLocations maps the Content-Location of the parts which have
+	// one, the URL the HTML body of a multipart/related message references
+	// them by, such as "http://example.com/logo.png", to the name of the file
+	// the part is written to, as ContentIDs does for the "cid:" URLs.
+	ContentLocations map[string]string
+
+	// Dir is the directory the files of the parts are written to, such as
+	// the directory created with Options.TempDir.
+	Dir string
+
+	// Preamble and Epilogue are the data of a multipart message before its
+	// first boundary and after its closing one, with Options.KeepPreamble,
+	// such as a note for the mailers which don't handle MIME, or data hidden
+	// there. They are nil if there is none, or without KeepPreamble. Only
+	// their first MaxPreambleLen bytes are kept, PreambleTruncated and
+	// EpilogueTruncated being set when they are longer.
+	Preamble          []byte
+	Epilogue          []byte
+	PreambleTruncated bool
+	EpilogueTruncated bool
+
+	// Set when the message is parsed with Options.KeepAttachments,
+	// Options.KeepTextBody, Options.HTMLTextFallback, and
+	// Options.IgnoreNameCase
+	attachmentsKept  bool
+	textBodyKept     bool
+	htmlTextFallback bool
+	ignoreNameCase   bool
+}
+
+// DecodeStatus tells how the data of a part was decoded from its
+// Content-Transfer-Encoding, see PartMeta.DecodeStatus.
+type DecodeStatus string
+
+const (
+	// DecodeOK is the status of a part decoded as is.
+	DecodeOK DecodeStatus = "ok"
+
+	// DecodeRepaired is the status of a part decoded once its defects were
+	// fixed, such as a base64 part with a byte order mark, sent with the
+	// URL-safe alphabet, or whose invalid characters were dropped with
+	// Options.LenientBase64.
+	DecodeRepaired DecodeStatus = "repaired"
+
+	// DecodeRaw is the status of a part written as received, without being
+	// decoded, with Options.NoDecode.
+	DecodeRaw DecodeStatus = "raw"
+
+	// DecodeFailed is the status of a part whose data couldn't be read or
+	// decoded, the error returned for it being a DecodeError.
+	DecodeFailed DecodeStatus = "failed"
+)
+
+// PartMeta describes a MIME part extracted from a message. Multipart MIME
+// parts aren't described, only the parts they hold.
+type PartMeta struct {
+
+	// Index is the position of the part in the message, from 1, in the
+	// order the parts were read.
+	Index int `json:"index"`
+
+	// Filename is the name of the file the part is written to, in the
+	// output directory.
+	Filename string `json:"filename"`
+
+	// Name is the file name given to the part by the message, in its
+	// Content-Disposition, once decoded and made safe, if any, whatever the
+	// name of its file, see Filename.
+	Name string `json:"name,omitempty"`
+
+	// Position is the position of the part in the tree of the MIME parts,
+	// its number at each level, from 1, separated by dots, such as "1.2" for
+	// the second part of the first part of the message, as in IMAP. The
+	// parts of an attached message are below the part of the message.
+	Position string `json:"position"`
+
+	// ContentType is the media type of the part, without its parameters.
+	ContentType string `json:"content_type"`
+
+	// ContentTransferEncoding is the Content-Transfer-Encoding of the part,
+	// in lower case, or empty if there is none.
+	ContentTransferEncoding string `json:"content_transfer_encoding"`
+
+	// Base64Variant is the name of the base64.Encoding a base64 part turned
+	// out to be encoded with, when it isn't the standard one, StdEncoding,
+	// but its URL-safe or unpadded variants, which are also decoded.
+	Base64Variant string `json:"base64_variant,omitempty"`
+
+	// Base64Remapped is the number of characters of the URL-safe alphabet
+	// turned into the ones of the standard alphabet in the data of a base64
+	// part, see Base64Variant.
+	Base64Remapped int `json:"base64_remapped,omitempty"`
+
+	// Base64Dropped is the number of invalid characters dropped from the
+	// data of a base64 part with Options.LenientBase64, including the
+	// padding found before the end of the data.
+	Base64Dropped int `json:"base64_dropped,omitempty"`
+
+	// Base64BOM is set when the data of a base64 part was preceded by a
+	// byte order mark, such as the UTF-8 one, which was dropped.
+	Base64BOM bool `json:"base64_bom,omitempty"`
+
+	// DecodeStatus tells how the data of the part was decoded, see
+	// DecodeStatus. It is empty for the parts which weren't decoded, such as
+	// the attachments skipped, or which couldn't be written.
+	DecodeStatus DecodeStatus `json:"decode_status,omitempty"`
+
+	// Size is the size of the part, in bytes, as declared by the "size"
+	// parameter of its Content-Disposition, or -1 if there is none.
+	Size int64 `json:"size"`
+
+	// Written is the number of bytes written for the part, once decoded.
+	Written int64 `json:"written"`
+
+	// SHA256 is the hex encoded SHA-256 checksum of the data written for
+	// the part, once decoded.
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Disposition is the Content-Disposition of the part, "inline" or
+	// "attachment". For a part without one, it is guessed: a text part
+	// without a file name is part of the body, so "inline", anything
+	// else is an "attachment".
+	Disposition string `json:"disposition"`
+
+	// Attachment is true for parts whose Disposition is "attachment",
+	// false for the parts to be displayed inline.
+	Attachment bool `json:"attachment"`
+
+	// Truncated is true for the parts which were only partly written
+	// because of Options.MaxBytesPerPart.
+	Truncated bool `json:"truncated"`
+
+	// Skipped is true for the parts which weren't written because they are
+	// filtered out by the options, such as Options.AttachmentsOnly.
+	Skipped bool `json:"skipped"`
+
+	// Error is the error met while extracting the part, if any.
+	Error string `json:"error,omitempty"`
+
+	// Charset is the charset parameter of the Content-Type of the part, if
+	// any, or Options.DefaultCharset for a text part without one.
+	Charset string `json:"charset,omitempty"`
+
+	// ContentID is the Content-ID of the part, without its angle brackets.
+	ContentID string `json:"content_id,omitempty"`
+
+	// ContentLocation is the Content-Location of the part, without the
+	// spaces of its folding, see RFC 2557.
+	ContentLocation string `json:"content_location,omitempty"`
+
+	// RawFilename is the name of the file the raw data of the part is
+	// written to with Options.WriteRaw, in the output directory.
+	RawFilename string `json:"raw_filename,omitempty"`
+
+	// Header holds all the header fields of the part, as they were read, such
+	// as its Content-Location or its custom X- fields. For the body of a
+	// message which isn't multipart, they are the header fields of the message.
+	Header textproto.MIMEHeader `json:"header,omitempty"`
+
+	// Decoded data of the part, as written, only kept for the text parts
+	// making the body of the message with Options.KeepTextBody, and for the
+	// attachments with Options.KeepAttachments, and whether it was converted to UTF-8 from
+	// its charset with Options.ConvertCharset
+	content   []byte
+	converted bool
+}
+
+// defaultContentType is the Content-Type of the parts which have none, as
+// RFC 2045 says.
+const defaultContentType = "text/plain; charset=us-ascii"
+
+// contentType returns the Content-Type of the part described by header, or
+// defaultContentType if it has none.
+func contentType(header textproto.MIMEHeader) string {
+
+	value := header.Get("Content-Type")
+	if len(strings.TrimSpace(value)) == 0 {
+		return defaultContentType
+	}
+
+	return value
+
+}
+
+// parseContentType parses the Content-Type of the part described by header,
+// see contentType, as mime.ParseMediaType() does: the media type and the
+// names of the parameters are lowercased, such as "multipart/mixed" and
+// "boundary" for "Multipart/Mixed; BOUNDARY=x". A value mime.ParseMediaType()
+// rejects is parsed again once cleaned by cleanMediaType.
+func parseContentType(header textproto.MIMEHeader) (string, map[string]string, error) {
+
+	value := contentType(header)
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		if cleaned, cleaned_params, e := mime.ParseMediaType(cleanMediaType(value)); e == nil {
+			return cleaned, cleaned_params, nil
+		}
+	}
+
+	return mediaType, params, err
+
+}
+
+// cleanMediaType fixes the common defects of a Content-Type value which make
+// mime.ParseMediaType() fail: the comments, the spaces within the media type,
+// such as "multipart / mixed", the empty or malformed parameters, such as in
+// "text/plain;; charset=utf-8", the parameters without a value, such as
+// "name=" or `name=""`, and the repeated parameters, the first one being kept.
+func cleanMediaType(value string) string {
+
+	params := splitParams(removeComments(value))
+	cleaned := []string{strings.Join(strings.Fields(params[0]), "")}
+	seen := make(map[string]bool)
+
+	for _, param := range params[1:] {
+		key, value, found := strings.Cut(param, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if !found || len(key) == 0 || len(unquote(value)) == 0 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, key+"="+value)
+	}
+
+	return strings.Join(cleaned, "; ")
+
+}
+
+// removeComments removes the comments, between parentheses, from a header
+// value, except within its quoted strings. The comments may be nested.
+func removeComments(value string) string {
+
+	var b strings.Builder
+	quoted, escaped := false, false
+	depth := 0
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && (quoted || depth > 0):
+			escaped = true
+		case quoted:
+			quoted = c != '"'
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+			continue
+		case depth == 0 && c == '"':
+			quoted = true
+		}
+		if depth == 0 {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+
+}
+
+// newPartMeta builds the PartMeta of the part described by header, written
+// to filename.
+func newPartMeta(header textproto.MIMEHeader, filename string) PartMeta {
+
+	meta := PartMeta{Filename: filename, Size: -1, Header: header}
+
+	var params map[string]string
+	meta.ContentType, params, _ = parseContentType(header)
+	meta.Charset = params["charset"]
+
+	meta.ContentTransferEncoding = transferEncoding(header)
+
+	meta.ContentID = strings.Trim(strings.TrimSpace(header.Get("Content-Id")), "<>")
+
+	meta.ContentLocation = strings.Join(strings.Fields(header.Get("Content-Location")), "")
+
+	disposition, params, err := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if err == nil {
+		meta.Disposition = disposition
+		if size, err := strconv.ParseInt(params["size"], 10, 64); err == nil {
+			meta.Size = size
+		}
+	}
+
+	if meta.Disposition != "inline" && meta.Disposition != "attachment" {
+		meta.Disposition = "attachment"
+		if strings.HasPrefix(meta.ContentType, "text/") && len(dispositionFileName(header.Get("Content-Disposition"))) == 0 {
+			meta.Disposition = "inline"
+		}
+	}
+	meta.Attachment = meta.Disposition == "attachment"
+
+	return meta
+
+}
+
+// ParseEmail reads a MIME email from r, and decodes and writes each of its
+// MIME parts to a separate file. A message which isn't multipart is
+// written as a single part. The main headers of the
+// message are returned in a Message, along with any error met while
+// processing the MIME parts.
+func ParseEmail(r io.Reader, opts Options) (*Message, error) {
+	return ParseEmailContext(context.Background(), r, opts)
+}
+
+// ParseEmailContext does the job of ParseEmail, but stops as soon as ctx is
+// done, in which case ctx.Err() is returned. The context is checked between
+// the MIME parts, and while each of them is decoded and written.
+func ParseEmailContext(ctx context.Context, r io.Reader, opts Options) (*Message, error) {
+
+	// With opts.PartTimeout, no read of the message may block longer than
+	// the time given to the part being read, from its header to its end,
+	// including the header of the message itself
+	var deadline *partDeadline
+	var timeout *timeoutReader
+	if opts.PartTimeout > 0 {
+		deadline = newPartDeadline(opts.PartTimeout)
+		defer deadline.stop()
+		timeout = &timeoutReader{ctx: ctx, deadline: deadline, r: r}
+		r = timeout
+	}
+
+	//  Parse the message to separate the Header and the Body with mail.ReadMessage()
+	// With opts.HeadersFile, the header is recorded as it is read, to keep
+	// the order of its fields
+	var recorder *headerRecorder
+	if opts.HeadersFile {
+		recorder = &headerRecorder{}
+		r = io.TeeReader(r, recorder)
+	}
+
+	m, err := mail.ReadMessage(r)
+	if err != nil && timeout != nil && timeout.err != nil {
+		// The header is cut short by the timeout
+		err = timeout.err
+	}
+	if err != nil {
+		err = fmt.Errorf("parsing mail: %w: %w", ErrNotMIME, err)
+		if opts.Events != nil {
+			err = errors.Join(err, writeEvents(opts.Events, nil, opts.mboxIndex, err))
+		}
+		return nil, err
+	}
+
+	// The "From","To" and "Subject" headers have to be decoded if they were encoded
+	// using RFC 2047 to allow non ASCII characters, see DecodeHeader.
+	msg := &Message{
+		Header:      m.Header,
+		Headers:     parseHeaders(m.Header),
+		Date:        m.Header.Get("Date"),
+		ContentType: m.Header.Get("Content-Type"),
+	}
+	msg.From = strings.Join(msg.Headers.Values("From"), ", ")
+	msg.To = strings.Join(msg.Headers.Values("To"), ", ")
+	msg.Subject, _ = DecodeHeader(m.Header.Get("Subject"))
+
+	if opts.SubjectDir {
+		slug := SubjectSlug(m)
+		if len(slug) == 0 {
+			slug = "no-subject"
+		}
+		opts.OutputDir = filepath.Join(opts.OutputDir, slug)
+	}
+	if opts.TempDir && !opts.DryRun && opts.Archive == nil && opts.OnPart == nil && opts.FS == nil {
+		if len(opts.OutputDir) > 0 {
+			if err := os.MkdirAll(opts.OutputDir, opts.dirMode()); err != nil {
+				return msg, &WriteError{Path: opts.OutputDir, Err: err}
+			}
+		}
+		// The directory is only accessible to its owner, unless DirMode is set
+		dir, err := os.MkdirTemp(opts.OutputDir, "mimeparse-*")
+		if err == nil && opts.DirMode != 0 {
+			err = os.Chmod(dir, opts.DirMode)
+		}
+		if err != nil {
+			return msg, &WriteError{Path: opts.OutputDir, Err: err}
+		}
+		opts.OutputDir = dir
+	}
+	msg.Dir = opts.OutputDir
+
+	p := newParser(ctx, opts)
+	defer p.cancel()
+	p.deadline = deadline
+	p.date = msg.Headers.Date
+	p.senderDate = SenderDateRadix(msg.Headers)
+	if opts.Manifest {
+		p.uniqueName(ManifestName)
+	}
+	if opts.HeadersFile {
+		p.uniqueName(HeadersName)
+	}
+
+	// With opts.KeepPreamble, the body of a multipart message is recorded as
+	// it is read, for what the multipart.Reader discards
+	body := io.Reader(m.Body)
+	var preamble *preambleRecorder
+	mediaType, params, e := parseContentType(textproto.MIMEHeader(m.Header))
+	boundary := params["boundary"]
+	if len(opts.Boundary) > 0 {
+		boundary = opts.Boundary
+	}
+	if opts.KeepPreamble && len(boundary) > 0 && (e == nil && strings.HasPrefix(mediaType, "multipart/") || len(opts.Boundary) > 0) {
+		preamble = newPreambleRecorder(boundary)
+		body = io.TeeReader(m.Body, preamble)
+	}
+
+	if len(opts.Boundary) > 0 {
+		if e != nil || !strings.HasPrefix(mediaType, "multipart/") {
+			mediaType = "multipart/mixed"
+		}
+		msg.Parts, err = p.parsePart(body, opts.Boundary, 1, mediaType)
+	} else {
+		msg.Parts, err = p.parseBody(textproto.MIMEHeader(m.Header), body, "body", 1)
+	}
+
+	// The epilogue is what is left once the closing delimiter is read
+	if preamble != nil {
+		if preamble.closed {
+			if _, e := io.Copy(io.Discard, body); e != nil {
+				err = errors.Join(err, fmt.Errorf("reading epilogue: %w", e))
+			}
+		}
+		msg.Preamble, msg.Epilogue = preamble.preamble, preamble.epilogue
+		msg.PreambleTruncated, msg.EpilogueTruncated = preamble.preambleTruncated, preamble.epilogueTruncated
+	}
+	msg.Signed = p.signed
+	msg.attachmentsKept = opts.KeepAttachments
+	msg.textBodyKept = opts.KeepTextBody && !opts.DryRun
+	msg.htmlTextFallback = opts.HTMLTextFallback
+	msg.ignoreNameCase = opts.IgnoreNameCase
+	msg.Encrypted = p.encrypted
+
+	msg.ContentIDs = make(map[string]string)
+	msg.ContentLocations = make(map[string]string)
+	for _, part := range msg.Parts {
+		if len(part.ContentID) > 0 {
+			msg.ContentIDs[part.ContentID] = part.Filename
+		}
+		if len(part.ContentLocation) > 0 {
+			msg.ContentLocations[part.ContentLocation] = part.Filename
+		}
+		if part.Attachment {
+			msg.AttachmentCount++
+		} else {
+			msg.InlineCount++
+		}
+		msg.TotalBytesWritten += part.Written
+	}
+	msg.TotalParts = len(msg.Parts)
+
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if !opts.DryRun {
+		if opts.Manifest {
+			if e := writeManifest(msg.Parts, opts, p.date); e != nil {
+				err = errors.Join(err, e)
+			}
+		}
+		if opts.HeadersFile {
+			if e := writeHeaders(recorder.data, opts, p.date); e != nil {
+				err = errors.Join(err, e)
+			}
+		}
+	}
+
+	if opts.Events != nil {
+		if e := writeEvents(opts.Events, msg, opts.mboxIndex, err); e != nil {
+			err = errors.Join(err, e)
+		}
+	}
+
+	return msg, err
+
+}
@@ -0,0 +1,90 @@
+package mimeparse
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// FS is the file system the files of the parts are written to, see
+// Options.FS. The paths given to its methods are the ones of the files in the
+// output directory, Options.OutputDir, built with filepath.Join().
+type FS interface {
+
+	// Stat describes the file or directory name, failing with an error
+	// satisfying os.IsNotExist() if there is no such file.
+	Stat(name string) (os.FileInfo, error)
+
+	// MkdirAll creates the directory path along with its parents, with the
+	// permissions perm, doing nothing if it already exists.
+	MkdirAll(path string, perm os.FileMode) error
+
+	// Create creates the file name, with the permissions perm, truncating
+	// it if it already exists, to be written and closed by the caller.
+	Create(name string, perm os.FileMode) (io.WriteCloser, error)
+
+	// Remove removes the file name.
+	Remove(name string) error
+
+	// Chmod changes the permissions of the file name to mode.
+	Chmod(name string, mode os.FileMode) error
+
+	// Chtimes changes the access and modification times of the file name.
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// osFS is the FS of the operating system, used when Options.FS isn't set.
+type osFS struct{}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// fileSystem returns the FS the files are written to.
+func (opts Options) fileSystem() FS {
+
+	if opts.FS != nil {
+		return opts.FS
+	}
+
+	return osFS{}
+
+}
+
+// writeFile writes data to the file name of fsys, with the permissions perm,
+// as os.WriteFile() does.
+func writeFile(fsys FS, name string, data []byte, perm os.FileMode) error {
+
+	file, err := fsys.Create(name, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	return err
+
+}
@@ -0,0 +1,160 @@
+package mimeparse
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memFS is an in-memory FS, whose files are kept in files and directories in
+// dirs, by path.
+type memFS struct {
+	files map[string]*memFile
+	dirs  map[string]bool
+}
+
+// memFile is a file of a memFS.
+type memFile struct {
+	bytes.Buffer
+	mode   os.FileMode
+	mtime  time.Time
+	closed bool
+}
+
+func (f *memFile) Close() error {
+
+	f.closed = true
+
+	return nil
+
+}
+
+// memInfo describes a file or a directory of a memFS.
+type memInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() os.FileMode  { return 0 }
+func (i memInfo) ModTime() time.Time { return time.Time{} }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() any           { return nil }
+
+func newMemFS() *memFS {
+	return &memFS{files: make(map[string]*memFile), dirs: make(map[string]bool)}
+}
+
+func (m *memFS) Stat(name string) (os.FileInfo, error) {
+
+	if file, ok := m.files[name]; ok {
+		return memInfo{filepath.Base(name), int64(file.Len()), false}, nil
+	}
+	if m.dirs[name] {
+		return memInfo{filepath.Base(name), 0, true}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+
+}
+
+func (m *memFS) MkdirAll(path string, perm os.FileMode) error {
+
+	for ; path != "." && path != "/"; path = filepath.Dir(path) {
+		m.dirs[path] = true
+	}
+
+	return nil
+
+}
+
+func (m *memFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+
+	if dir := filepath.Dir(name); dir != "." && !m.dirs[dir] {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrNotExist}
+	}
+	m.files[name] = &memFile{mode: perm}
+
+	return m.files[name], nil
+
+}
+
+func (m *memFS) Remove(name string) error {
+
+	delete(m.files, name)
+
+	return nil
+
+}
+
+func (m *memFS) Chmod(name string, mode os.FileMode) error {
+
+	if file, ok := m.files[name]; ok {
+		file.mode = mode
+	}
+
+	return nil
+
+}
+
+func (m *memFS) Chtimes(name string, atime, mtime time.Time) error {
+
+	if file, ok := m.files[name]; ok {
+		file.mtime = mtime
+	}
+
+	return nil
+
+}
+
+// With FS, the files are all written to it, and nothing to the disk.
+func TestFS(t *testing.T) {
+
+	fsys := newMemFS()
+	dir := filepath.Join(t.TempDir(), "out")
+	message := "Date: Mon, 2 Jan 2023 10:00:00 +0100\r\n" + invoiceMessage
+	opts := Options{FS: fsys, OutputDir: dir, Manifest: true, HeadersFile: true, WriteRaw: true, PreserveDate: true, FileMode: 0o600, Verbosity: Quiet}
+	if _, err := ParseEmail(strings.NewReader(message), opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("got %v for the output directory, want it not to exist on disk", err)
+	}
+	if !fsys.dirs[dir] {
+		t.Errorf("output directory %q not created", dir)
+	}
+
+	want := map[string]string{
+		"XX-1.txt": "Here they are.", "invoice.pdf": "first invoice", "items.csv": "a,b", "Invoice (1).PDF": "second invoice",
+	}
+	for name, data := range want {
+		file, ok := fsys.files[filepath.Join(dir, name)]
+		if !ok || file.String() != data || !file.closed {
+			t.Errorf("%s: got %v, want %q", name, file, data)
+			continue
+		}
+		if file.mode != 0o600 || !file.mtime.Equal(time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)) {
+			t.Errorf("%s: got mode %o, time %v", name, file.mode, file.mtime)
+		}
+		if _, ok := fsys.files[filepath.Join(dir, name+RawSuffix)]; !ok {
+			t.Errorf("%s: no raw file", name)
+		}
+	}
+	for _, name := range []string{ManifestName, HeadersName} {
+		if _, ok := fsys.files[filepath.Join(dir, name)]; !ok {
+			t.Errorf("%s not written", name)
+		}
+	}
+	if len(fsys.files) != 2*len(want)+2 {
+		t.Errorf("got %d files, want %d", len(fsys.files), 2*len(want)+2)
+	}
+
+}
@@ -0,0 +1,906 @@
+package mimeparse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPart is a part extracted by parseTest, with its decoded data.
+type testPart struct {
+	name string
+	data string
+}
+
+// parseTest parses the message read from r with opts, handing the parts to
+// OnPart, and returns them in the order they were extracted, along with the
+// message and the error returned by ParseEmailContext.
+func parseTest(t *testing.T, r io.Reader, opts Options) ([]testPart, *Message, error) {
+
+	t.Helper()
+
+	var parts []testPart
+	opts.OnPart = func(meta PartMeta, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		parts = append(parts, testPart{meta.Filename, string(data)})
+		return err
+	}
+
+	msg, err := ParseEmailContext(context.Background(), r, opts)
+
+	return parts, msg, err
+
+}
+
+// parseFixture parses the file name of testdata as parseTest does.
+func parseFixture(t *testing.T, name string, opts Options) ([]testPart, *Message, error) {
+
+	t.Helper()
+
+	return parseTest(t, bytes.NewReader(readFixture(t, name)), opts)
+
+}
+
+// readFixture returns the content of the file name of testdata.
+func readFixture(t *testing.T, name string) []byte {
+
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+
+}
+
+// checkParts fails t if got isn't want, part by part.
+func checkParts(t *testing.T, got, want []testPart) {
+
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d parts %q, want %d parts %q", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("part %d: got %q, want %q", i+1, got[i], want[i])
+		}
+	}
+
+}
+
+// The fixtures of testdata, with the parts described by testdata/README.md.
+func TestParseFixtures(t *testing.T) {
+
+	// The message attached is the end of the part, before the closing delimiter
+	_, attached, _ := strings.Cut(string(readFixture(t, "nested.eml")), "Content-Type: message/rfc822\r\n\r\n")
+	attached, _, _ = strings.Cut(attached, "\r\n--outer--")
+
+	pixel := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\b\x06\x00\x00\x00\x1f\x15\xc4\x89\x00\x00\x00\rIDATx\xdac\xf8\xff\xff?\x00\x05\xfe\x02\xfe\xa7\xd6\xa4\xc5\x00\x00\x00\x00IEND\xaeB`\x82"
+
+	tests := []struct {
+		fixture string
+		opts    Options
+		parts   []testPart
+	}{
+		{"mixed.eml", Options{}, []testPart{
+			{"mixed-boundary-1.txt", "Please find the report attached."},
+			{"report.csv", "quarter,total\r\nQ1,42"},
+		}},
+		{"alternative.eml", Options{}, []testPart{
+			{"alt-boundary-1.txt", "Hello Bob"},
+			{"alt-boundary-2.html", "<p>Hello <b>Bob</b></p>"},
+		}},
+		{"nested.eml", Options{}, []testPart{
+			{"inner-1.txt", "See the forwarded message."},
+			{"inner-2.html", "<p>See the forwarded message.</p>"},
+			{"outer-1.eml", attached},
+		}},
+		{"nested.eml", Options{ParseAttachedMessages: true}, []testPart{
+			{"inner-1.txt", "See the forwarded message."},
+			{"inner-2.html", "<p>See the forwarded message.</p>"},
+			{"message-2.txt", "Forwarded body"},
+		}},
+		{"base64.eml", Options{}, []testPart{
+			{"b64-1.txt", "Un pixel joint, voilà.\n"},
+			{"pixel.png", pixel},
+		}},
+		{"quoted-printable.eml", Options{}, []testPart{
+			{"body-1.txt", "Un café crème, s'il vous plaît, avec une ligne assez longue pour être coupée.\r\n"},
+		}},
+		{"flowed.eml", Options{}, []testPart{
+			{"body-1.txt", "This paragraph is wrapped  \r\nover two lines.\r\n> A quoted line  \r\n> wrapped too.\r\n" +
+				"The last paragraph ends  \r\nwith a soft line break. \r\n"},
+		}},
+		{"flowed.eml", Options{Unflow: true}, []testPart{
+			{"body-1.txt", "This paragraph is wrapped over two lines.\r\n> A quoted line wrapped too.\r\n" +
+				"The last paragraph ends with a soft line break.\r\n"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+
+			parts, msg, err := parseFixture(t, test.fixture, test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			checkParts(t, parts, test.parts)
+			if msg.TotalParts != len(test.parts) {
+				t.Errorf("got TotalParts %d, want %d", msg.TotalParts, len(test.parts))
+			}
+
+		})
+	}
+
+}
+
+// A message cut short is reported as truncated, even within the header of a
+// part, by ParseEmail, CountAttachments and ParseStructure.
+func TestTruncated(t *testing.T) {
+
+	header := "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n"
+	tests := []struct {
+		name  string
+		body  string
+		parts int
+	}{
+		{"part header", "--XX\r\nContent-Type: text/pl", 0},
+		{"second part header", "--XX\r\nContent-Type: text/plain\r\n\r\nhello\r\n--XX\r\nContent-Type: text/pl", 1},
+		{"part data", "--XX\r\nContent-Type: text/plain\r\n\r\nhello", 0},
+		{"no part", "preamble only\r\n", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parts, _, err := parseTest(t, strings.NewReader(header+test.body), Options{})
+			if !errors.Is(err, ErrTruncated) {
+				t.Errorf("ParseEmail: got error %v, want ErrTruncated", err)
+			}
+			if len(parts) < test.parts {
+				t.Errorf("ParseEmail: got %d parts, want at least %d", len(parts), test.parts)
+			}
+
+			if _, err := CountAttachments(strings.NewReader(header+test.body), Options{}); !errors.Is(err, ErrTruncated) {
+				t.Errorf("CountAttachments: got error %v, want ErrTruncated", err)
+			}
+
+			if _, err := ParseStructure(strings.NewReader(header+test.body), Options{}); !errors.Is(err, ErrTruncated) {
+				t.Errorf("ParseStructure: got error %v, want ErrTruncated", err)
+			}
+
+		})
+	}
+
+	// The closing delimiter ends the parts, even without a line break
+	complete := header + "--XX\r\nContent-Type: text/plain\r\n\r\nhello\r\n--XX--"
+	if _, _, err := parseTest(t, strings.NewReader(complete), Options{}); err != nil {
+		t.Errorf("ParseEmail: unexpected error %v", err)
+	}
+	if _, err := CountAttachments(strings.NewReader(complete), Options{}); err != nil {
+		t.Errorf("CountAttachments: unexpected error %v", err)
+	}
+	if _, err := ParseStructure(strings.NewReader(complete), Options{}); err != nil {
+		t.Errorf("ParseStructure: unexpected error %v", err)
+	}
+
+}
+
+// With MaxBytesPerPart, the data kept in memory is the one written, truncated
+// to the limit.
+func TestMaxBytesPerPartKept(t *testing.T) {
+
+	dir := t.TempDir()
+	m, err := ParseEmail(bytes.NewReader(readFixture(t, "mixed.eml")), Options{OutputDir: dir, MaxBytesPerPart: 8, KeepAttachments: true, KeepTextBody: true, Verbosity: Quiet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	text, err := m.TextBody()
+	if err != nil || text != "Please f" {
+		t.Errorf("TextBody: got %q, %v, want %q", text, err, "Please f")
+	}
+
+	attachments, err := m.Attachments()
+	if err != nil || len(attachments) != 1 {
+		t.Fatalf("Attachments: got %d attachments, %v, want 1", len(attachments), err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "report.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(attachments[0].Data) != "quarter," || string(data) != "quarter," {
+		t.Errorf("got kept data %q and file %q, want %q", attachments[0].Data, data, "quarter,")
+	}
+	if !m.Parts[1].Truncated {
+		t.Errorf("report.csv not marked as truncated")
+	}
+
+}
+
+// The parts whose Content-Type is malformed, or of a type without any known
+// extension, are named with the radix and the index, and a .bin extension.
+func TestMalformedContentType(t *testing.T) {
+
+	tests := []string{
+		"text/",
+		"image/",
+		"; charset=utf-8",
+		"application/octet-stream; name=",
+		"application/x-made-up",
+	}
+
+	for _, value := range tests {
+		t.Run(value, func(t *testing.T) {
+
+			dir := t.TempDir()
+			message := "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n" +
+				"--XX\r\nContent-Type: " + value + "\r\n\r\n\x00\x01\x02\r\n--XX--\r\n"
+			m, err := ParseEmail(strings.NewReader(message), Options{OutputDir: dir, Verbosity: Quiet})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(m.Parts) != 1 || m.Parts[0].Filename != "XX-1.bin" {
+				t.Fatalf("got parts %+v, want XX-1.bin", m.Parts)
+			}
+			if data, err := os.ReadFile(filepath.Join(dir, "XX-1.bin")); err != nil || string(data) != "\x00\x01\x02" {
+				t.Errorf("got file %q, %v, want %q", data, err, "\x00\x01\x02")
+			}
+
+			// The body of a message which isn't multipart is named the same way
+			single := "From: alice@example.com\r\nContent-Type: " + value + "\r\n\r\n\x00\x01\x02"
+			m, err = ParseEmail(strings.NewReader(single), Options{DryRun: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(m.Parts) != 1 || m.Parts[0].Filename != "body-1.bin" {
+				t.Errorf("got parts %+v, want body-1.bin", m.Parts)
+			}
+
+		})
+	}
+
+}
+
+// The Content-Type values with odd casing, spaces, comments or empty
+// parameters are parsed all the same.
+func TestParseContentType(t *testing.T) {
+
+	tests := []struct {
+		value     string
+		mediaType string
+		params    map[string]string
+	}{
+		{"Multipart/Mixed; BOUNDARY=XX", "multipart/mixed", map[string]string{"boundary": "XX"}},
+		{"multipart / mixed; boundary=XX", "multipart/mixed", map[string]string{"boundary": "XX"}},
+		{"multipart/mixed (comment); boundary=\"XX\"", "multipart/mixed", map[string]string{"boundary": "XX"}},
+		{"text/plain;; charset=utf-8", "text/plain", map[string]string{"charset": "utf-8"}},
+		{"application/octet-stream; name=", "application/octet-stream", map[string]string{}},
+		{"application/octet-stream; name=; charset=utf-8", "application/octet-stream", map[string]string{"charset": "utf-8"}},
+		{"text/plain; charset=utf-8; charset=iso-8859-1", "text/plain", map[string]string{"charset": "utf-8"}},
+	}
+
+	for _, test := range tests {
+
+		mediaType, params, err := parseContentType(textproto.MIMEHeader{"Content-Type": {test.value}})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.value, err)
+			continue
+		}
+		if mediaType != test.mediaType || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%q: got %q %q, want %q %q", test.value, mediaType, params, test.mediaType, test.params)
+		}
+
+	}
+
+}
+
+// With NoDecode, the parts are written as received, named with their
+// encoding, which IncludeNames doesn't match.
+func TestNoDecode(t *testing.T) {
+
+	parts, _, err := parseFixture(t, "base64.eml", Options{NoDecode: true, IncludeNames: []string{"*.png"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkParts(t, parts, []testPart{
+		{"pixel.png.base64", "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP4//8/AAX+Av6n1qTFAAAAAElFTkSuQmCC"},
+	})
+
+}
+
+// nestedMessage returns a message made of depth multipart parts nested in
+// each other, the last one holding a single text/plain part.
+func nestedMessage(depth int) string {
+
+	var b strings.Builder
+	b.WriteString("From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=b0\r\n\r\n")
+	for i := 1; i < depth; i++ {
+		fmt.Fprintf(&b, "--b%d\r\nContent-Type: multipart/mixed; boundary=b%d\r\n\r\n", i-1, i)
+	}
+	fmt.Fprintf(&b, "--b%d\r\nContent-Type: text/plain\r\n\r\nleaf\r\n", depth-1)
+	for i := depth - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "--b%d--\r\n", i)
+	}
+
+	return b.String()
+
+}
+
+// The multipart parts nested deeper than MaxDepth are skipped with an error,
+// however deep the message goes.
+func TestMaxDepth(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		depth int
+		opts  Options
+		parts int
+	}{
+		{"default", DefaultMaxDepth, Options{}, 1},
+		{"default exceeded", DefaultMaxDepth + 1, Options{}, 0},
+		{"pathological", 10000, Options{}, 0},
+		{"option", 5, Options{MaxDepth: 5}, 1},
+		{"option exceeded", 6, Options{MaxDepth: 5}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parts, msg, err := parseTest(t, strings.NewReader(nestedMessage(test.depth)), test.opts)
+			if msg == nil || len(parts) != test.parts {
+				t.Fatalf("got %d parts, want %d", len(parts), test.parts)
+			}
+			if test.parts == 0 && (err == nil || !strings.Contains(err.Error(), "nested multipart levels")) {
+				t.Errorf("got error %v, want too many nested levels", err)
+			}
+			if test.parts > 0 && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+		})
+	}
+
+}
+
+// The extraction stops once MaxTotalBytes are written, and the parts larger
+// than MaxPartSize aren't written, without leaving files behind.
+func TestSizeLimits(t *testing.T) {
+
+	message := attachmentsMessage(4, 1000)
+
+	tests := []struct {
+		name  string
+		opts  Options
+		files []string
+	}{
+		{"no limit", Options{}, []string{"file-1.bin", "file-2.bin", "file-3.bin", "file-4.bin"}},
+		{"total", Options{MaxTotalBytes: 2500}, []string{"file-1.bin", "file-2.bin"}},
+		{"part", Options{MaxPartSize: 999}, nil},
+		{"part exact", Options{MaxPartSize: 1000, MaxTotalBytes: 3000}, []string{"file-1.bin", "file-2.bin", "file-3.bin"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			test.opts.OutputDir, test.opts.Verbosity = t.TempDir(), Quiet
+			m, err := ParseEmail(bytes.NewReader(message), test.opts)
+			if len(test.files) < 4 && !errors.Is(err, ErrTooLarge) {
+				t.Errorf("got error %v, want ErrTooLarge", err)
+			}
+			if len(test.files) == 4 && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			entries, err := os.ReadDir(test.opts.OutputDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			var written int64
+			for _, entry := range entries {
+				files = append(files, entry.Name())
+			}
+			for _, part := range m.Parts {
+				written += part.Written
+			}
+			if !reflect.DeepEqual(files, test.files) {
+				t.Errorf("got files %q, want %q", files, test.files)
+			}
+			if written != int64(len(test.files))*1000 {
+				t.Errorf("got %d bytes written, want %d", written, len(test.files)*1000)
+			}
+
+		})
+	}
+
+}
+
+// The parts of an attached message are extracted with ParseAttachedMessages,
+// at a level of their own, so they aren't named like the parts around it.
+func TestAttachedMessage(t *testing.T) {
+
+	message := "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n" +
+		"--XX\r\nContent-Type: text/plain\r\n\r\nouter text\r\n" +
+		"--XX\r\nContent-Type: message/rfc822\r\n\r\n" +
+		"From: bob@example.com\r\nContent-Type: multipart/mixed; boundary=YY\r\n\r\n" +
+		"--YY\r\nContent-Type: text/plain\r\n\r\ninner text\r\n" +
+		"--YY\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=a.pdf\r\n\r\ninner pdf\r\n" +
+		"--YY--\r\n" +
+		"\r\n--XX--\r\n"
+
+	parts, m, err := parseTest(t, strings.NewReader(message), Options{ParseAttachedMessages: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkParts(t, parts, []testPart{{"XX-1.txt", "outer text"}, {"YY-1.txt", "inner text"}, {"a.pdf", "inner pdf"}})
+
+	var positions []string
+	for _, part := range m.Parts {
+		positions = append(positions, part.Position)
+	}
+	if want := []string{"1", "2.1", "2.2"}; !reflect.DeepEqual(positions, want) {
+		t.Errorf("got positions %q, want %q", positions, want)
+	}
+
+	// Otherwise the attached message is a part as any other
+	parts, _, err = parseTest(t, strings.NewReader(message), Options{})
+	if err != nil || len(parts) != 2 || parts[1].name != "XX-2.eml" {
+		t.Errorf("got parts %q, %v, want XX-1.txt and XX-2.eml", parts, err)
+	}
+
+}
+
+// The checksum of each part is the one of its decoded data, whether it is
+// written by a worker or not.
+func TestSHA256(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		message string
+		opts    Options
+		part    int
+		want    string
+	}{
+		{"base64", singlePartMessage("application/octet-stream", "base64", "aGVs\r\nbG8=\r\n"), Options{}, 0, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{"attachment", invoiceMessage, Options{}, 1, "8d7638a2325c6f2785ce83b7e035eeae7db232cd47069e64957ea8925aaa670d"},
+		{"workers", invoiceMessage, Options{Workers: 4}, 1, "8d7638a2325c6f2785ce83b7e035eeae7db232cd47069e64957ea8925aaa670d"},
+		{"dry run", invoiceMessage, Options{DryRun: true}, 1, "8d7638a2325c6f2785ce83b7e035eeae7db232cd47069e64957ea8925aaa670d"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			test.opts.OutputDir, test.opts.Verbosity = t.TempDir(), Quiet
+			m, err := ParseEmail(strings.NewReader(test.message), test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := m.Parts[test.part].SHA256; got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+
+		})
+	}
+
+}
+
+// With PreserveDate, the files are dated like the message.
+func TestPreserveDate(t *testing.T) {
+
+	message := "Date: Mon, 2 Jan 2023 10:00:00 +0100\r\n" + invoiceMessage
+	want := time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	dir := t.TempDir()
+	if _, err := ParseEmail(strings.NewReader(message), Options{OutputDir: dir, PreserveDate: true, Verbosity: Quiet}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"XX-1.txt", "invoice.pdf", "items.csv"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(want) {
+			t.Errorf("%s: got %v, want %v", name, info.ModTime(), want)
+		}
+	}
+
+	// Otherwise, the files are dated when they are written
+	dir = t.TempDir()
+	if _, err := ParseEmail(strings.NewReader(message), Options{OutputDir: dir, Verbosity: Quiet}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "invoice.pdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(info.ModTime()) > time.Hour {
+		t.Errorf("got %v, want the current time", info.ModTime())
+	}
+
+}
+
+// filterMessage is a message with parts of several types, some of them in
+// nested multipart parts.
+const filterMessage = "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n" +
+	"--XX\r\nContent-Type: multipart/alternative; boundary=YY\r\n\r\n" +
+	"--YY\r\nContent-Type: text/plain\r\n\r\ntext\r\n" +
+	"--YY\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n" +
+	"--YY--\r\n" +
+	"--XX\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=invoice.pdf\r\n\r\ninvoice\r\n" +
+	"--XX\r\nContent-Type: image/PNG\r\nContent-Disposition: inline; filename=logo.png\r\n\r\nlogo\r\n" +
+	"--XX\r\nContent-Type: multipart/mixed; boundary=ZZ\r\n\r\n" +
+	"--ZZ\r\nContent-Type: Application/PDF\r\nContent-Disposition: attachment; filename=receipt.pdf\r\n\r\nreceipt\r\n" +
+	"--ZZ\r\nContent-Type: application/vnd.openxmlformats-officedocument.spreadsheetml.sheet\r\nContent-Disposition: attachment; filename=report-2023.xlsx\r\n\r\nsheet\r\n" +
+	"--ZZ--\r\n" +
+	"--XX--\r\n"
+
+// The parts are filtered upon their media type, the multipart parts being
+// gone through whatever the patterns.
+func TestFilterTypes(t *testing.T) {
+
+	pdfs := []testPart{{"invoice.pdf", "invoice"}, {"receipt.pdf", "receipt"}}
+
+	tests := []struct {
+		name  string
+		opts  Options
+		parts []testPart
+	}{
+		{"include", Options{IncludeTypes: []string{"application/pdf"}}, pdfs},
+		{"exclude", Options{ExcludeTypes: []string{"text/*", "image/*", "application/vnd.*"}}, pdfs},
+		{"both", Options{IncludeTypes: []string{"application/*", "image/png"}, ExcludeTypes: []string{"application/vnd.*", "image/*"}}, pdfs},
+		{"multipart", Options{IncludeTypes: []string{"multipart/*"}}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parts, m, err := parseTest(t, strings.NewReader(filterMessage), test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			checkParts(t, parts, test.parts)
+
+			skipped := 0
+			for _, part := range m.Parts {
+				if part.Skipped {
+					skipped++
+				}
+			}
+			if skipped != len(m.Parts)-len(test.parts) || len(m.Parts) != 6 {
+				t.Errorf("got %d parts, %d skipped, want 6, %d skipped", len(m.Parts), skipped, 6-len(test.parts))
+			}
+
+		})
+	}
+
+}
+
+// signedMessage is a multipart/signed message, whose signature is the second
+// part.
+const signedMessage = "From: alice@example.com\r\n" +
+	"Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256; boundary=XX\r\n\r\n" +
+	"--XX\r\nContent-Type: text/plain\r\n\r\nSigned text\r\n" +
+	"--XX\r\nContent-Type: application/pkcs7-signature; name=smime.p7s\r\nContent-Transfer-Encoding: base64\r\n\r\nAAEC\r\n" +
+	"--XX--\r\n"
+
+// The signature of a signed message is skipped with SkipSignatures, the
+// signed content being extracted all the same.
+func TestSignedMessage(t *testing.T) {
+
+	parts, m, err := parseTest(t, strings.NewReader(signedMessage), Options{SkipSignatures: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkParts(t, parts, []testPart{{"XX-1.txt", "Signed text"}})
+	if !m.Signed || len(m.Parts) != 2 || !m.Parts[1].Skipped {
+		t.Errorf("got Signed %v, parts %v, want a signed message with its signature skipped", m.Signed, m.Parts)
+	}
+
+	parts, m, err = parseTest(t, strings.NewReader(signedMessage), Options{})
+	if err != nil || !m.Signed || len(parts) != 2 || parts[1].name != "XX-2.p7s" {
+		t.Errorf("got parts %q, %v, signed %v, want the signature written to XX-2.p7s", parts, err, m.Signed)
+	}
+
+	parts, m, err = parseTest(t, strings.NewReader(invoiceMessage), Options{SkipSignatures: true})
+	if err != nil || m.Signed || len(parts) != 4 {
+		t.Errorf("got %d parts, %v, signed %v, want 4 parts of a message which isn't signed", len(parts), err, m.Signed)
+	}
+
+}
+
+// The PGP/MIME and S/MIME encrypted messages are reported as such, their
+// encrypted parts being skipped with SkipEncrypted.
+func TestEncryptedMessage(t *testing.T) {
+
+	pgp := "From: alice@example.com\r\n" +
+		"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=XX\r\n\r\n" +
+		"--XX\r\nContent-Type: application/pgp-encrypted\r\n\r\nVersion: 1\r\n" +
+		"--XX\r\nContent-Type: application/octet-stream; name=encrypted.asc\r\n\r\n-----BEGIN PGP MESSAGE-----\r\n" +
+		"--XX--\r\n"
+	smime := singlePartMessage("application/pkcs7-mime; smime-type=enveloped-data; name=smime.p7m", "base64", "AAEC\r\n")
+	signed := singlePartMessage("application/pkcs7-mime; smime-type=signed-data; name=smime.p7m", "base64", "AAEC\r\n")
+
+	tests := []struct {
+		name      string
+		message   string
+		opts      Options
+		encrypted bool
+		written   int
+	}{
+		{"PGP", pgp, Options{}, true, 2},
+		{"PGP skipped", pgp, Options{SkipEncrypted: true}, true, 0},
+		{"S/MIME", smime, Options{}, true, 1},
+		{"S/MIME skipped", smime, Options{SkipEncrypted: true}, true, 0},
+		{"S/MIME signed", signed, Options{SkipEncrypted: true}, false, 1},
+		{"clear", invoiceMessage, Options{SkipEncrypted: true}, false, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parts, m, err := parseTest(t, strings.NewReader(test.message), test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if m.Encrypted != test.encrypted || len(parts) != test.written {
+				t.Errorf("got Encrypted %v, %d parts written, want %v, %d", m.Encrypted, len(parts), test.encrypted, test.written)
+			}
+
+		})
+	}
+
+}
+
+// The parts are filtered upon their file name, along with their media type,
+// the part having to match both, or either of them with MatchAny.
+func TestFilterNames(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		opts  Options
+		parts []string
+	}{
+		{"names", Options{IncludeNames: []string{"*.xlsx"}}, []string{"report-2023.xlsx"}},
+		{"case", Options{IncludeNames: []string{"REPORT-*.XLSX", "logo.*"}}, []string{"logo.png", "report-2023.xlsx"}},
+		{"built names", Options{IncludeNames: []string{"YY-*"}}, []string{"YY-1.txt", "YY-2.html"}},
+		{"both", Options{IncludeNames: []string{"*.pdf", "*.png"}, IncludeTypes: []string{"application/*"}}, []string{"invoice.pdf", "receipt.pdf"}},
+		{"any", Options{IncludeNames: []string{"*.png"}, IncludeTypes: []string{"application/pdf"}, MatchAny: true}, []string{"invoice.pdf", "logo.png", "receipt.pdf"}},
+		{"exclude", Options{IncludeNames: []string{"*.pdf", "*.png"}, ExcludeTypes: []string{"image/*"}, MatchAny: true}, []string{"invoice.pdf", "receipt.pdf"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			parts, _, err := parseTest(t, strings.NewReader(filterMessage), test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, part := range parts {
+				names = append(names, part.name)
+			}
+			if !reflect.DeepEqual(names, test.parts) {
+				t.Errorf("got %q, want %q", names, test.parts)
+			}
+
+		})
+	}
+
+}
+
+// handPart returns a part built by hand, with the header fields of header
+// and the data data, read from a reader of its own.
+func handPart(t *testing.T, header textproto.MIMEHeader, data string) *multipart.Part {
+
+	t.Helper()
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	pw, err := w.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(pw, data)
+	w.Close()
+
+	part, err := multipart.NewReader(&b, w.Boundary()).NextRawPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return part
+
+}
+
+// The parts given to WriteAllParts are decoded and written, their files being
+// named upon their position when they have no file name.
+func TestWriteAllParts(t *testing.T) {
+
+	parts := []*multipart.Part{
+		handPart(t, textproto.MIMEHeader{"Content-Type": {"text/plain"}, "Content-Transfer-Encoding": {"quoted-printable"}}, "caf=C3=A9"),
+		handPart(t, textproto.MIMEHeader{"Content-Type": {"application/pdf"}, "Content-Disposition": {"attachment; filename=a.pdf"}, "Content-Transfer-Encoding": {"base64"}}, "JVBERi0="),
+		handPart(t, textproto.MIMEHeader{"Content-Type": {"image/png"}, "Content-Transfer-Encoding": {"x-unknown"}}, "???"),
+		handPart(t, textproto.MIMEHeader{"Content-Type": {"image/png"}}, "png"),
+	}
+
+	dir := t.TempDir()
+	metas, err := WriteAllParts(parts, Options{OutputDir: dir, Verbosity: Quiet})
+	var decode_error *DecodeError
+	if !errors.As(err, &decode_error) {
+		t.Errorf("got error %v, want the DecodeError of the third part", err)
+	}
+	if len(metas) != 4 {
+		t.Fatalf("got %d parts, want 4", len(metas))
+	}
+
+	for i, want := range []struct{ filename, position, data string }{
+		{"part-1.txt", "1", "café"}, {"a.pdf", "2", "%PDF-"}, {"part-3.png", "3", ""}, {"part-4.png", "4", "png"},
+	} {
+		if metas[i].Filename != want.filename || metas[i].Position != want.position {
+			t.Errorf("part %d: got %q at %q, want %q at %q", i+1, metas[i].Filename, metas[i].Position, want.filename, want.position)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, want.filename))
+		if i == 2 {
+			if err == nil {
+				t.Errorf("part 3: got file %q, want none", want.filename)
+			}
+			continue
+		}
+		if err != nil || string(data) != want.data {
+			t.Errorf("part %d: got %q, %v, want %q", i+1, data, err, want.data)
+		}
+	}
+
+	// The extraction stops at the first failure with FailFast
+	parts = []*multipart.Part{
+		handPart(t, textproto.MIMEHeader{"Content-Type": {"image/png"}, "Content-Transfer-Encoding": {"x-unknown"}}, "???"),
+		handPart(t, textproto.MIMEHeader{"Content-Type": {"image/png"}}, "png"),
+	}
+	metas, err = WriteAllParts(parts, Options{DryRun: true, FailFast: true})
+	if err == nil || len(metas) != 1 {
+		t.Errorf("got %d parts, %v, want 1 part and an error", len(metas), err)
+	}
+
+}
+
+// With Boundary, the message is parsed as multipart whatever its
+// Content-Type, even when it is malformed or has no boundary.
+func TestBoundaryOption(t *testing.T) {
+
+	body := "--XX\r\nContent-Type: text/plain\r\n\r\nHello\r\n" +
+		"--XX\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=a.pdf\r\n\r\npdf\r\n" +
+		"--XX--\r\n"
+	want := []testPart{{"XX-1.txt", "Hello"}, {"a.pdf", "pdf"}}
+
+	for _, content_type := range []string{"multipart/mixed", "multipart/mixed; boundary=\"\"", "multipart/; boundary=YY", "text/plain", ""} {
+
+		message := "From: alice@example.com\r\nContent-Type: " + content_type + "\r\n\r\n" + body
+		parts, _, err := parseTest(t, strings.NewReader(message), Options{Boundary: "XX"})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", content_type, err)
+			continue
+		}
+		checkParts(t, parts, want)
+
+	}
+
+	// Without it, the message can't be parsed as multipart
+	message := "From: alice@example.com\r\nContent-Type: multipart/mixed\r\n\r\n" + body
+	if _, _, err := parseTest(t, strings.NewReader(message), Options{}); !errors.Is(err, ErrNoBoundary) {
+		t.Errorf("got error %v, want ErrNoBoundary", err)
+	}
+
+}
+
+// The extraction stops with ErrTooManyParts after MaxParts parts, counted
+// across the nested multipart parts.
+func TestMaxParts(t *testing.T) {
+
+	for _, max_parts := range []int{1, 3, 5} {
+
+		parts, m, err := parseTest(t, strings.NewReader(filterMessage), Options{MaxParts: max_parts})
+		if !errors.Is(err, ErrTooManyParts) {
+			t.Errorf("%d: got error %v, want ErrTooManyParts", max_parts, err)
+		}
+		if len(parts) != max_parts || len(m.Parts) != max_parts {
+			t.Errorf("%d: got %d parts, want %d", max_parts, len(parts), max_parts)
+		}
+
+	}
+
+	parts, _, err := parseTest(t, strings.NewReader(filterMessage), Options{MaxParts: 6})
+	if err != nil || len(parts) != 6 {
+		t.Errorf("got %d parts, %v, want 6", len(parts), err)
+	}
+
+	// A flood of parts is stopped just the same
+	message := attachmentsMessage(10000, 1)
+	parts, _, err = parseTest(t, bytes.NewReader(message), Options{MaxParts: 100})
+	if !errors.Is(err, ErrTooManyParts) || len(parts) != 100 {
+		t.Errorf("got %d parts, %v, want 100 and ErrTooManyParts", len(parts), err)
+	}
+
+}
+
+// The body of a message which isn't multipart is extracted as a single part,
+// named upon the Content-Type of the message.
+func TestParseSinglePart(t *testing.T) {
+
+	header := textproto.MIMEHeader{"Content-Type": {"application/pdf"}, "Content-Transfer-Encoding": {"base64"}}
+	dir := t.TempDir()
+	meta, err := ParseSinglePart(header, strings.NewReader("JVBERi0=\r\n"), "body", Options{OutputDir: dir, Verbosity: Quiet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.Filename != "body-1.pdf" || meta.Written != 5 || meta.ContentType != "application/pdf" {
+		t.Errorf("got %+v, want body-1.pdf of 5 bytes", meta)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "body-1.pdf")); err != nil || string(data) != "%PDF-" {
+		t.Errorf("got %q, %v, want %q", data, err, "%PDF-")
+	}
+
+	// So is the body of a message without a Content-Type, as text/plain
+	parts, m, err := parseTest(t, strings.NewReader("From: alice@example.com\r\n\r\nHello\r\n"), Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkParts(t, parts, []testPart{{"body-1.txt", "Hello\r\n"}})
+	if m.Parts[0].Position != "1" || m.Parts[0].Charset != "us-ascii" {
+		t.Errorf("got position %q, charset %q, want 1 and us-ascii", m.Parts[0].Position, m.Parts[0].Charset)
+	}
+
+}
+
+// DecodePart decodes a part on the fly, and WritePart writes it to a file,
+// which isn't left behind if the part can't be decoded.
+func TestDecodePart(t *testing.T) {
+
+	part := handPart(t, textproto.MIMEHeader{"Content-Transfer-Encoding": {"Quoted-Printable"}}, "caf=C3=A9 =\r\ncr=C3=A8me")
+	r, err := DecodePart(part)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != "café crème" {
+		t.Errorf("got %q, %v, want %q", data, err, "café crème")
+	}
+
+	part = handPart(t, textproto.MIMEHeader{"Content-Transfer-Encoding": {"x-unknown"}}, "data")
+	if _, err := DecodePart(part); err == nil {
+		t.Error("got no error for an unknown encoding")
+	}
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "a.pdf")
+	part = handPart(t, textproto.MIMEHeader{"Content-Transfer-Encoding": {"base64"}}, "JVBERi0=")
+	if err := WritePart(part, filename); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := os.ReadFile(filename); err != nil || string(data) != "%PDF-" {
+		t.Errorf("got %q, %v, want %q", data, err, "%PDF-")
+	}
+
+	filename = filepath.Join(dir, "b.pdf")
+	part = handPart(t, textproto.MIMEHeader{"Content-Transfer-Encoding": {"base64"}}, "JVBE!!!Ri0=")
+	var decode_error *DecodeError
+	if err := WritePart(part, filename); !errors.As(err, &decode_error) || decode_error.Step != "decoding base64" {
+		t.Errorf("got error %v, want a DecodeError decoding base64", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("got %v, want the file removed", err)
+	}
+
+}
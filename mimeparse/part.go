@@ -0,0 +1,1406 @@
+package mimeparse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WitePart decodes the data of MIME part and writes it to the file filename.
+// An error is returned, naming the step that failed and the file targeted,
+// if the part can't be read, decoded or written.
+func WritePart(part *multipart.Part, filename string) error {
+
+	decoder, err := DecodePart(part)
+	if err != nil {
+		return &DecodeError{Filename: filename, Err: err}
+	}
+
+	// The reader returned by DecodePart knows the step which failed
+	_, err = writeDecoded(osFS{}, decoder.(*stepReader), filename, -1, DefaultFileMode)
+
+	return err
+
+}
+
+// DecodePart returns a reader yielding the data of the MIME part decoded
+// according to its Content-Transfer-Encoding: base64, quoted-printable, or
+// none for 7bit, 8bit and binary. The data is decoded on the fly as it is
+// read. An error is returned for an unknown Content-Transfer-Encoding, and
+// by the reader if the data is malformed.
+func DecodePart(part *multipart.Part) (io.Reader, error) {
+
+	decoder, err := newDecoder(part.Header, part)
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder, nil
+
+}
+
+// writeDecoded writes the data read from decoder to the file filename. The
+// data is decoded on the fly while being copied to the file, so whatever the
+// size of the part, only a small buffer is held in memory. The file is
+// removed if the part can't be entirely decoded and written, or if its
+// decoded data is more than limit bytes, unless limit is negative. The file
+// is created in fsys with the permissions perm, before umask. The number of
+// bytes written is returned.
+func writeDecoded(fsys FS, decoder *stepReader, filename string, limit int64, perm os.FileMode) (int64, error) {
+
+	file, err := fsys.Create(filename, perm)
+	if err != nil {
+		return 0, &WriteError{Path: filename, Err: err}
+	}
+
+	written, err := copyPart(file, decoder, filename, limit)
+	if e := file.Close(); err == nil && e != nil {
+		err = &WriteError{Path: filename, Err: e}
+	}
+
+	if err != nil {
+		fsys.Remove(filename)
+		return 0, err
+	}
+
+	return written, nil
+
+}
+
+// transferEncoding returns the Content-Transfer-Encoding found in header, in
+// lower case, without the spaces, quotes, comments or parameters some mailers
+// add around it, such as in "base64 (encoded by X)" or "base64;".
+func transferEncoding(header textproto.MIMEHeader) string {
+
+	encoding := header.Get("Content-Transfer-Encoding")
+	if i := strings.IndexAny(encoding, "(;"); i >= 0 {
+		encoding = encoding[:i]
+	}
+
+	return strings.ToLower(strings.Trim(encoding, " \t\"'"))
+
+}
+
+// newDecoder returns a reader decoding body according to the
+// Content-Transfer-Encoding found in header.
+func newDecoder(header textproto.MIMEHeader, body io.Reader) (*stepReader, error) {
+
+	content_transfer_encoding := transferEncoding(header)
+
+	switch content_transfer_encoding {
+
+	case "base64":
+		cleaner := &base64Cleaner{r: body}
+		return &stepReader{r: base64.NewDecoder(base64.StdEncoding, cleaner), step: "decoding base64", base64: cleaner}, nil
+
+	case "quoted-printable":
+		return &stepReader{r: quotedprintable.NewReader(&softBreakTrimmer{r: body}), step: "decoding quoted-printable"}, nil
+
+	case "7bit", "8bit", "binary", "":
+		// No encoding was performed, the data is written as is. A part
+		// without Content-Transfer-Encoding is 7BIT by default.
+		return &stepReader{r: body, step: "reading MIME part data"}, nil
+
+	}
+
+	return nil, fmt.Errorf("unknown Content-Transfer-Encoding %q", content_transfer_encoding)
+
+}
+
+// copyPart copies the data read from decoder to w, failing with ErrTooLarge
+// if there are more than limit bytes, unless limit is negative. Filename is
+// only used to give some context to the errors.
+func copyPart(w io.Writer, decoder *stepReader, filename string, limit int64) (int64, error) {
+
+	// Read one byte more than the limit, to know if it is exceeded
+	var data io.Reader = decoder
+	if limit >= 0 {
+		data = io.LimitReader(decoder, limit+1)
+	}
+
+	// The data goes through a buffer of a fixed size, reused from a part
+	// to the next one, whatever the size of the part. The io.ReaderFrom of
+	// w, if any, is hidden, as an *os.File would allocate a buffer of its own.
+	buffer := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buffer)
+	written, err := io.CopyBuffer(writerOnly{w}, data, *buffer)
+	if err == nil && limit >= 0 && written > limit {
+		err = ErrTooLarge
+	}
+
+	if err != nil {
+		if decoder.err != nil {
+			return written, &DecodeError{Filename: filename, Step: decoder.step, Err: decoder.err}
+		}
+		return written, &WriteError{Path: filename, Err: err}
+	}
+
+	return written, nil
+
+}
+
+// copyBufferSize is the size of the buffers the parts are copied through.
+const copyBufferSize = 32 * 1024
+
+// copyBuffers holds the buffers the parts are copied through by copyPart.
+var copyBuffers = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, copyBufferSize)
+		return &buffer
+	},
+}
+
+// writerOnly hides all the methods of an io.Writer but Write.
+type writerOnly struct {
+	io.Writer
+}
+
+// handPart hands the data read from decoder to opts.OnPart, failing with
+// ErrTooLarge if the callback tries to read more than limit bytes, unless
+// limit is negative. The number of bytes read by the callback is returned.
+func (p *parser) handPart(meta PartMeta, decoder *stepReader, limit int64) (int64, error) {
+
+	data := &limitedReader{r: decoder, limit: limit}
+	err := p.opts.OnPart(meta, data)
+
+	switch {
+	case decoder.err != nil:
+		return data.n, &DecodeError{Filename: meta.Filename, Step: decoder.step, Err: decoder.err}
+	case data.exceeded:
+		return data.n, fmt.Errorf("handing MIME part %q: %w", meta.Filename, ErrTooLarge)
+	case err != nil:
+		return data.n, fmt.Errorf("handing MIME part %q: %w", meta.Filename, err)
+	}
+
+	return data.n, nil
+
+}
+
+// limitedReader reads from r, failing with ErrTooLarge once more than limit
+// bytes are available, unless limit is negative. N is the number of bytes read.
+type limitedReader struct {
+	r        io.Reader
+	limit    int64
+	n        int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+
+	if l.limit >= 0 && l.n >= l.limit {
+		// Anything past the limit makes the part too large
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			l.exceeded = true
+			return 0, ErrTooLarge
+		}
+		return 0, err
+	}
+
+	if l.limit >= 0 && int64(len(p)) > l.limit-l.n {
+		p = p[:l.limit-l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+
+	return n, err
+
+}
+
+// truncatingReader reads at most left bytes from r, then ends as if there
+// were nothing more to read, setting truncated if there was.
+type truncatingReader struct {
+	r         io.Reader
+	left      int64
+	truncated bool
+}
+
+func (t *truncatingReader) Read(p []byte) (int, error) {
+
+	if t.left <= 0 {
+		var probe [1]byte
+		if n, _ := io.ReadFull(t.r, probe[:]); n > 0 {
+			t.truncated = true
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > t.left {
+		p = p[:t.left]
+	}
+	n, err := t.r.Read(p)
+	t.left -= int64(n)
+
+	return n, err
+
+}
+
+// errReader fails with err, as the rest of a part which couldn't be entirely
+// read in memory.
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
+
+// contextReader reads from r as long as ctx isn't done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.r.Read(p)
+
+}
+
+// partDeadline is the deadline of opts.PartTimeout for the part being read,
+// armed again for each part.
+type partDeadline struct {
+	timeout time.Duration
+
+	// Guards timer and expired, as the timer fires in a goroutine of its own
+	mu    sync.Mutex
+	timer *time.Timer
+
+	// Closed once the deadline is exceeded
+	expired chan struct{}
+}
+
+// newPartDeadline returns a partDeadline, armed for timeout.
+func newPartDeadline(timeout time.Duration) *partDeadline {
+
+	d := &partDeadline{timeout: timeout}
+	d.start()
+
+	return d
+
+}
+
+// start arms the deadline again, for the next part, if there is one.
+func (d *partDeadline) start() {
+
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	expired := make(chan struct{})
+	d.expired = expired
+	d.timer = time.AfterFunc(d.timeout, func() { close(expired) })
+
+}
+
+// stop disarms the deadline, once there is nothing more to read.
+func (d *partDeadline) stop() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.timer.Stop()
+
+}
+
+// done returns a channel closed once the deadline is exceeded.
+func (d *partDeadline) done() <-chan struct{} {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.expired
+
+}
+
+// timeoutReader reads from r in a goroutine of its own, so a read can be
+// abandoned, even while it is blocked, failing with ErrPartTimeout once
+// deadline is exceeded, or with the error of ctx once it is done. Nothing is
+// read anymore afterwards, but the read abandoned may still be going on.
+type timeoutReader struct {
+	ctx      context.Context
+	deadline *partDeadline
+	r        io.Reader
+
+	// The data is read in a buffer of its own, which p can't be once the
+	// read is abandoned
+	buffer []byte
+	err    error
+}
+
+// readResult is the outcome of a read of timeoutReader.
+type readResult struct {
+	n   int
+	err error
+}
+
+func (t *timeoutReader) Read(p []byte) (int, error) {
+
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	if len(t.buffer) < len(p) {
+		t.buffer = make([]byte, len(p))
+	}
+	buffer := t.buffer[:len(p)]
+
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := t.r.Read(buffer)
+		done <- readResult{n, err}
+	}()
+
+	select {
+	case result := <-done:
+		return copy(p, buffer[:result.n]), result.err
+	case <-t.deadline.done():
+		t.err = ErrPartTimeout
+	case <-t.ctx.Done():
+		t.err = t.ctx.Err()
+	}
+
+	return 0, t.err
+
+}
+
+// stepReader remembers the error returned by r, so a failure while reading
+// and decoding a part can be told apart from a failure while writing it. Step
+// describes what r is doing, to give some context to the error.
+type stepReader struct {
+	r    io.Reader
+	step string
+	err  error
+
+	// The base64 data read by the decoder, if it is a base64 one
+	base64 *base64Cleaner
+}
+
+func (s *stepReader) Read(p []byte) (int, error) {
+
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+
+	return n, err
+
+}
+
+// parser holds what has to be shared by all the MIME parts of a message
+// while it is parsed, as ParsePart is called for each nested level.
+type parser struct {
+	ctx  context.Context
+	opts Options
+
+	// Cancels ctx once a part has failed with opts.FailFast, so the parts
+	// being read, or written by the workers, are abandoned at once
+	cancel context.CancelFunc
+
+	// Number of bytes written so far, for all the parts
+	written int64
+
+	// Set once opts.MaxTotalBytes or opts.MaxParts has been exceeded, or a
+	// part has failed with opts.FailFast, or exceeded opts.PartTimeout, to
+	// stop the parsing
+	full   bool
+	failed bool
+
+	// File names already given to the parts, in lower case
+	used map[string]bool
+
+	// Number of parts extracted so far
+	count int
+
+	// Date of the message, for opts.PreserveDate
+	date time.Time
+
+	// Start of the file names, for opts.SenderDateNames
+	senderDate string
+
+	// Position of the part being parsed, its number at each level of the
+	// tree of the MIME parts
+	position []int
+
+	// Set once a signature part is met, for Message.Signed
+	signed bool
+
+	// Set once an encrypted part is met, for Message.Encrypted, and number
+	// of multipart/encrypted parts being parsed
+	encrypted   bool
+	inEncrypted int
+
+	// Guards written, full, failed and used, as the parts may be written by
+	// several workers with opts.Workers
+	mu sync.Mutex
+
+	// Holds a token for each part being extracted by a worker, with
+	// opts.Workers, nil otherwise
+	workers chan struct{}
+
+	// Deadline of the data of the message, armed again for each part read,
+	// with opts.PartTimeout, nil otherwise
+	deadline *partDeadline
+}
+
+// newParser returns a parser for a message, stopping as soon as ctx is done.
+// The parser must be released with its cancel function once done.
+func newParser(ctx context.Context, opts Options) *parser {
+
+	// The date and the sender are unknown, unless set by the caller from the
+	// header of the message
+	p := &parser{opts: opts, senderDate: SenderDateRadix(Headers{})}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	if opts.Workers > 1 {
+		p.workers = make(chan struct{}, opts.Workers)
+	}
+
+	return p
+
+}
+
+// stopped reports whether the extraction has to stop, once opts.MaxTotalBytes
+// has been exceeded, or a part has failed with opts.FailFast.
+func (p *parser) stopped() bool {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.full || p.failed
+
+}
+
+// checkPartCount fails with ErrTooManyParts, stopping the extraction, if
+// opts.MaxParts parts have already been extracted, before another one is.
+func (p *parser) checkPartCount() error {
+
+	if p.opts.MaxParts <= 0 || p.count < p.opts.MaxParts {
+		return nil
+	}
+
+	p.mu.Lock()
+	p.full = true
+	p.mu.Unlock()
+
+	return fmt.Errorf("stopping the extraction after %d parts: %w", p.count, ErrTooManyParts)
+
+}
+
+// partFailed records err, the failure of the part described by meta, in
+// meta.Error, and stops the extraction with opts.FailFast. The extraction
+// also stops when opts.PartTimeout is exceeded, as the data of the part may
+// still be being read. With opts.FailFast, the context of the parser is
+// cancelled, as the part may have failed in a worker, while the next parts
+// are read, or written by the other workers.
+func (p *parser) partFailed(meta *PartMeta, err error) {
+
+	meta.Error = err.Error()
+
+	if p.opts.FailFast || errors.Is(err, ErrPartTimeout) {
+		p.mu.Lock()
+		p.failed = true
+		p.mu.Unlock()
+	}
+	if p.opts.FailFast {
+		p.cancel()
+	}
+
+}
+
+// discard is the logger used when Options.Logger is nil.
+var discard = log.New(io.Discard, "", 0)
+
+// logger returns the logger where the diagnostics of the parsing of the given
+// level are written, which discards them if opts.Verbosity is lower.
+func (p *parser) logger(level Verbosity) *log.Logger {
+
+	if p.opts.Logger == nil || p.opts.Verbosity < level {
+		return discard
+	}
+
+	return p.opts.Logger
+
+}
+
+// ParsePart parses the MIME part from mime_data, each part being separated by
+// boundary. If one of the part read is itself a multipart MIME part, the
+// function calls itself to recursively parse all the parts. The parts read
+// are decoded and written to separate files, named uppon their Content-Descrption
+// (or boundary if no Content-Description available) with the appropriate
+// file extension. The parts of each level are numbered from 1, and their
+// number is used in building the filename where the part is written, as to
+// ensure all filenames are distinct. Index is incremented at each recursive
+// level. The files are written in the opts.OutputDir directory, which
+// is created if needed. With opts.DryRun, the parts are walked through but none of
+// them is written. Unless opts.FailFast, a failure on one part doesn't stop the
+// parsing of the next ones, even a malformed part, as far as the next part can be
+// found: all the errors met are returned together once every part has been processed.
+// Index is also the depth of the parsing, limited by opts.MaxDepth.
+// The parts extracted, including the ones of the nested multipart MIME parts,
+// are returned in the order they were read.
+func ParsePart(mime_data io.Reader, boundary string, index int, opts Options) ([]PartMeta, error) {
+	p := newParser(context.Background(), opts)
+	defer p.cancel()
+	return p.parsePart(mime_data, boundary, index, "")
+}
+
+// parsePart does the job of ParsePart, with the state of the parser. MediaType
+// is the one of the multipart part parsed, if known. The parts of a
+// multipart/digest have message/rfc822 as default Content-Type, as per RFC
+// 2046, rather than text/plain. They are messages of their own, and parsed as
+// such whatever opts.ParseAttachedMessages. The parts of a multipart/encrypted
+// are encrypted, see Message.Encrypted.
+func (p *parser) parsePart(mime_data io.Reader, boundary string, index int, mediaType string) ([]PartMeta, error) {
+
+	// Don't go any deeper than allowed, or a crafted message could
+	// exhaust the stack
+	if index > p.opts.maxDepth() {
+		return nil, fmt.Errorf("skipping MIME parts of %q: more than %d nested multipart levels", boundary, p.opts.maxDepth())
+	}
+
+	// Without a boundary, multipart.NewReader() would only fail on the first
+	// part with an obscure error
+	if len(boundary) == 0 {
+		return nil, fmt.Errorf("going through the MIME parts at level %d: %w", index, ErrNoBoundary)
+	}
+
+	digest := mediaType == "multipart/digest"
+	if mediaType == "multipart/encrypted" {
+		p.encrypted = true
+		p.inEncrypted++
+		defer func() { p.inEncrypted-- }()
+	}
+
+	// Instantiate a new io.Reader dedicated to MIME multipart parsing
+	// using multipart.NewReader()
+	closing := newClosingReader(mime_data, boundary)
+	reader := multipart.NewReader(closing, boundary)
+	if reader == nil {
+		return nil, nil
+	}
+
+	// The parts extracted at this level, or below, and the errors met, in
+	// the order the parts are read. With opts.Workers, the results of the
+	// parts which aren't multipart are filled in by the workers.
+	var results []*partResult
+	var errs []error
+	var wg sync.WaitGroup
+
+	// Position of the part at this level, to name the parts without a file
+	// name of their own apart from each other
+	part_index := 0
+
+	// Number of errors in a row while looking for the next part
+	resyncing := 0
+
+	// Go through each of the MIME part of the message Body with NextRawPart(),
+	// which unlike NextPart() doesn't hide the Content-Transfer-Encoding of
+	// the quoted-printable parts, and decode them with newDecoder()
+	for {
+
+		// Once a part has failed with opts.FailFast, the context is
+		// cancelled, which isn't an error of its own
+		if err := p.ctx.Err(); err != nil {
+			if !p.stopped() {
+				errs = append(errs, err)
+			}
+			break
+		}
+
+		// The time given to a part starts with the reading of its header
+		p.deadline.start()
+
+		new_part, err := nextRawPart(reader, closing)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Unless opts.FailFast, try to go on with the next part, the
+			// reader skipping the lines up to the next boundary, one at a
+			// time. Only the first error is kept for the lines skipped.
+			if resyncing == 0 {
+				errs = append(errs, fmt.Errorf("going through the MIME parts of %q: %w", boundary, err))
+			}
+			resyncing++
+			if p.opts.FailFast || errors.Is(err, ErrTruncated) || errors.Is(err, ErrPartTimeout) || resyncing > maxResync {
+				break
+			}
+			continue
+		}
+		resyncing = 0
+
+		if digest && len(strings.TrimSpace(new_part.Header.Get("Content-Type"))) == 0 {
+			new_part.Header.Set("Content-Type", "message/rfc822")
+		}
+
+		result := &partResult{}
+		results = append(results, result)
+
+		// Position of the part in the tree of the MIME parts of the message
+		p.position = append(p.position, len(results))
+
+		// With opts.SniffMessages, a mislabeled message is parsed as such
+		var body io.Reader = new_part
+		sniffed := false
+		mediaType, params, err := parseContentType(new_part.Header)
+		if err == nil && mediaType == "application/octet-stream" && p.opts.SniffMessages {
+			body, sniffed = sniffMessage(new_part.Header, new_part)
+		}
+
+		if err == nil && strings.HasPrefix(mediaType, "multipart/") {
+			result.parts, result.err = p.parsePart(body, params["boundary"], index+1, mediaType)
+		} else if err == nil && (mediaType == "message/rfc822" && (p.opts.ParseAttachedMessages || digest) || sniffed) {
+			result.parts, result.err = p.parseAttachedMessage(new_part.Header, body, index+1)
+		} else if e := p.checkPartCount(); e != nil {
+			result.err = e
+		} else if p.workers != nil {
+			part_index++
+			p.extractAsync(new_part.Header, body, boundary, part_index, result, &wg)
+		} else {
+			part_index++
+			meta, err := p.extractPart(new_part.Header, body, boundary, part_index)
+			result.parts, result.err = []PartMeta{meta}, err
+		}
+
+		p.position = p.position[:len(p.position)-1]
+
+		if result.err != nil && p.opts.FailFast {
+			break
+		}
+		if p.stopped() {
+			break
+		}
+
+	}
+
+	wg.Wait()
+
+	var parts []PartMeta
+	var part_errs []error
+	for _, result := range results {
+		parts = append(parts, result.parts...)
+		if result.err != nil {
+			part_errs = append(part_errs, result.err)
+		}
+	}
+
+	return parts, errors.Join(append(part_errs, errs...)...)
+
+}
+
+// maxResync is the maximum number of lines skipped while looking for the
+// next MIME part after a malformed one.
+const maxResync = 100000
+
+// closingReader reads the data of a multipart part from r, setting closed
+// once the closing delimiter, "--" and the boundary followed by "--", is
+// read at the start of a line. The multipart.Reader gives io.EOF both at the
+// closing delimiter and when the data ends within the header of a part, so
+// only the first is the end of the parts.
+type closingReader struct {
+	r       io.Reader
+	closing []byte
+	closed  bool
+
+	// The end of the data read so far, which may start the delimiter, and
+	// the buffer where it is joined to the start of the next data read,
+	// reused from a read to the next one
+	tail, joined []byte
+}
+
+func newClosingReader(r io.Reader, boundary string) *closingReader {
+
+	// The start of the data is the start of a line
+	return &closingReader{r: r, closing: []byte("\n--" + boundary + "--"), tail: []byte("\n")}
+
+}
+
+func (c *closingReader) Read(p []byte) (int, error) {
+
+	n, err := c.r.Read(p)
+	if c.closed || n == 0 {
+		return n, err
+	}
+
+	// The delimiter may be split between the data held and the data read
+	data := p[:n]
+	keep := len(c.closing) - 1
+	start := data
+	if len(start) > keep {
+		start = start[:keep]
+	}
+	c.joined = append(append(c.joined[:0], c.tail...), start...)
+	if bytes.Contains(c.joined, c.closing) || bytes.Contains(data, c.closing) {
+		c.closed = true
+		return n, err
+	}
+
+	end := data
+	if len(end) < keep {
+		end = c.joined
+	}
+	if len(end) > keep {
+		end = end[len(end)-keep:]
+	}
+	c.tail = append(c.tail[:0], end...)
+
+	return n, err
+
+}
+
+// nextRawPart returns the next part read by reader, from the data read
+// through closing, as multipart.Reader.NextRawPart() does, but failing with
+// an error wrapping ErrTruncated, rather than io.EOF, if the data ends
+// before the closing delimiter, such as within the header of a part.
+func nextRawPart(reader *multipart.Reader, closing *closingReader) (*multipart.Part, error) {
+
+	part, err := reader.NextRawPart()
+	switch {
+	case err == io.EOF && closing.closed:
+		return nil, io.EOF
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return nil, fmt.Errorf("%w: %w", ErrTruncated, err)
+	}
+
+	return part, err
+
+}
+
+// partResult is the outcome of the extraction of a MIME part, with the parts
+// it holds if it is multipart.
+type partResult struct {
+	parts []PartMeta
+	err   error
+}
+
+// extractAsync extracts the MIME part described by header, which isn't
+// multipart, and whose data is read from part, with one of the opts.Workers
+// workers, storing the outcome in result once wg is done. The data of the part
+// is read in memory, and the part named, beforehand, so the parts are named in
+// the order they are read whatever the workers.
+func (p *parser) extractAsync(header textproto.MIMEHeader, part io.Reader, radix string, index int, result *partResult, wg *sync.WaitGroup) {
+
+	// Wait for a free worker before reading the data, so there are never
+	// more than opts.Workers parts held in memory
+	p.workers <- struct{}{}
+
+	// The reading is abandoned as soon as the context is done, such as when
+	// another part fails with opts.FailFast
+	var body io.Reader
+	data, err := io.ReadAll(contextReader{p.ctx, part})
+	body = bytes.NewReader(data)
+	if err != nil {
+		body = io.MultiReader(body, errReader{err})
+	}
+
+	pending := p.preparePart(header, body, radix, index)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() { <-p.workers }()
+		meta, err := p.writePart(pending)
+		if err != nil {
+			p.partFailed(&meta, err)
+		}
+		result.parts, result.err = []PartMeta{meta}, err
+	}()
+
+}
+
+// skip reports whether the part described by meta, whose file is named name
+// before the prefix of opts.PositionalNames and the suffix of uniqueName(), is
+// filtered out by the options, and must not be written.
+func (p *parser) skip(meta PartMeta, name string) bool {
+
+	if p.opts.AttachmentsOnly && !meta.Attachment {
+		return true
+	}
+
+	if p.opts.SkipSignatures && isSignature(meta.ContentType) {
+		return true
+	}
+
+	if matchType(p.opts.ExcludeTypes, meta.ContentType) {
+		return true
+	}
+
+	by_type := len(p.opts.IncludeTypes) > 0
+	by_name := len(p.opts.IncludeNames) > 0
+	type_matched := matchType(p.opts.IncludeTypes, meta.ContentType)
+	name_matched := matchType(p.opts.IncludeNames, name)
+
+	switch {
+	case by_type && by_name && p.opts.MatchAny:
+		return !type_matched && !name_matched
+	case by_type && !type_matched:
+		return true
+	case by_name && !name_matched:
+		return true
+	}
+
+	return false
+
+}
+
+// signatureTypes are the media types of the parts holding the cryptographic
+// signature of a message, PGP/MIME or S/MIME.
+var signatureTypes = map[string]bool{
+	"application/pgp-signature":     true,
+	"application/pkcs7-signature":   true,
+	"application/x-pkcs7-signature": true,
+}
+
+// isSignature reports whether mediaType is the one of a signature part.
+func isSignature(mediaType string) bool {
+	return signatureTypes[strings.ToLower(mediaType)]
+}
+
+// isEncrypted reports whether the part described by header is an S/MIME
+// encrypted message, an application/pkcs7-mime part which isn't only signed.
+func isEncrypted(header textproto.MIMEHeader) bool {
+
+	mediaType, params, err := parseContentType(header)
+	if err != nil || (mediaType != "application/pkcs7-mime" && mediaType != "application/x-pkcs7-mime") {
+		return false
+	}
+
+	switch strings.ToLower(params["smime-type"]) {
+	case "", "enveloped-data", "authenveloped-data":
+		return true
+	}
+
+	return false
+
+}
+
+// matchType reports whether mediaType matches one of patterns, as described
+// for Options.IncludeTypes. The malformed patterns never match. It is used for
+// the file names of Options.IncludeNames too.
+func matchType(patterns []string, mediaType string) bool {
+
+	mediaType = strings.ToLower(mediaType)
+	for _, pattern := range patterns {
+		if matched, err := path.Match(strings.ToLower(strings.TrimSpace(pattern)), mediaType); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// parseBody parses body, the body of a message described by header. A multipart
+// body is parsed with parsePart, starting at the level index. A message which
+// isn't multipart, or has no Content-Type at all, has a body made of a single
+// part, described by the header of the message: it is extracted as such, its
+// file being named with radix and index.
+func (p *parser) parseBody(header textproto.MIMEHeader, body io.Reader, radix string, index int) ([]PartMeta, error) {
+
+	mediaType, params, err := parseContentType(header)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		// Such a body is the part 1 of the message
+		if err := p.checkPartCount(); err != nil {
+			return nil, err
+		}
+		p.position = append(p.position, 1)
+		defer func() { p.position = p.position[:len(p.position)-1] }()
+		p.deadline.start()
+		meta, err := p.extractPart(header, body, radix, index)
+		return []PartMeta{meta}, err
+	}
+
+	// Recursivey parsed the MIME parts of the Body, starting with the first
+	// level where the MIME parts are separated with params["boundary"].
+	return p.parsePart(body, params["boundary"], index, mediaType)
+
+}
+
+// parseAttachedMessage parses a message/rfc822 part described by header, an
+// email attached to the message, such as a forwarded email, whose data is
+// read from body, to extract its own MIME parts. They are extracted at the
+// level index, so the files of the attached message are named apart from the
+// ones of the enclosing message.
+func (p *parser) parseAttachedMessage(header textproto.MIMEHeader, body io.Reader, index int) ([]PartMeta, error) {
+
+	decoder, err := newDecoder(header, body)
+	if err != nil {
+		return nil, fmt.Errorf("decoding attached message: %w", err)
+	}
+
+	m, err := mail.ReadMessage(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("parsing attached message: %w: %w", ErrNotMIME, err)
+	}
+
+	// The signature and the encryption of the attached message aren't the
+	// ones of the message
+	signed, encrypted := p.signed, p.encrypted
+	defer func() { p.signed, p.encrypted = signed, encrypted }()
+
+	return p.parseBody(textproto.MIMEHeader(m.Header), m.Body, "message", index)
+
+}
+
+// messageSniffLen is the number of bytes of data looked at by sniffMessage,
+// which has to hold the whole header of the message.
+const messageSniffLen = 8192
+
+// sniffMessage reports whether the part described by header, whose data is
+// read from r, is actually a multipart message, its decoded data starting
+// with a header whose Content-Type is multipart, with a boundary, such as a
+// forwarded message mislabeled as application/octet-stream, see
+// Options.SniffMessages. The start of the data is read to find out: the data
+// of the part has to be read from the reader returned instead of r.
+func sniffMessage(header textproto.MIMEHeader, r io.Reader) (io.Reader, bool) {
+
+	buffered := bufio.NewReaderSize(r, messageSniffLen)
+	data, _ := buffered.Peek(messageSniffLen)
+
+	// The end of the data peeked may not be decoded, but it is past the
+	// header of the message
+	decoder, err := newDecoder(header, bytes.NewReader(data))
+	if err != nil {
+		return buffered, false
+	}
+	decoded, _ := io.ReadAll(decoder)
+
+	m, err := mail.ReadMessage(bytes.NewReader(decoded))
+	if err != nil || len(m.Header["Content-Type"]) == 0 {
+		return buffered, false
+	}
+	mediaType, params, err := parseContentType(textproto.MIMEHeader(m.Header))
+
+	return buffered, err == nil && strings.HasPrefix(mediaType, "multipart/") && len(params["boundary"]) > 0
+
+}
+
+// ParseSinglePart handles the body of a message that isn't multipart, as if
+// it were a single MIME part described by the header of the message: the body
+// is decoded and written to a file named with BuildFileName, using radix, in
+// the opts.OutputDir directory.
+func ParseSinglePart(header textproto.MIMEHeader, body io.Reader, radix string, opts Options) (PartMeta, error) {
+	p := newParser(context.Background(), opts)
+	defer p.cancel()
+	return p.extractPart(header, body, radix, 1)
+}
+
+// WriteAllParts decodes and writes each of parts, which aren't multipart, to
+// a file in the opts.OutputDir directory, as ParseEmail does for the parts of
+// a message, but without walking through a message: the parts are taken as
+// they are, such as parts built by hand. The files of the parts without a
+// file name of their own are named "part-1", "part-2", ... upon their
+// position in parts, which is also their PartMeta.Position. The PartMeta of
+// the parts are returned in the same order, along with all the errors met.
+// The data of each part must still be readable: the data of a part read with
+// a multipart.Reader is lost once the next part is read, so the parts have to
+// come from different readers.
+func WriteAllParts(parts []*multipart.Part, opts Options) ([]PartMeta, error) {
+
+	p := newParser(context.Background(), opts)
+	defer p.cancel()
+
+	var metas []PartMeta
+	var errs []error
+	for i, part := range parts {
+
+		if p.stopped() {
+			break
+		}
+		if err := p.checkPartCount(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		p.position = []int{i + 1}
+		meta, err := p.extractPart(part.Header, part, "part", i+1)
+		metas = append(metas, meta)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+	}
+
+	return metas, errors.Join(errs...)
+
+}
+
+// extractPart decodes and writes body, the data of a MIME part which isn't
+// multipart, to a file in the opts.OutputDir directory, named upon header
+// with radix and index. With opts.DryRun, the part is decoded just the same
+// but nothing is written, so the errors and the PartMeta returned are the
+// ones of a normal run.
+func (p *parser) extractPart(header textproto.MIMEHeader, body io.Reader, radix string, index int) (PartMeta, error) {
+
+	meta, err := p.writePart(p.preparePart(header, body, radix, index))
+	if err != nil {
+		p.partFailed(&meta, err)
+	}
+
+	return meta, err
+
+}
+
+// decodeStatus returns the DecodeStatus of the part described by meta, once
+// written, err being the error met, if any.
+func (p *parser) decodeStatus(meta PartMeta, err error) DecodeStatus {
+
+	var decode_error *DecodeError
+
+	switch {
+	case errors.As(err, &decode_error):
+		return DecodeFailed
+	case err != nil:
+		return ""
+	case p.opts.NoDecode:
+		return DecodeRaw
+	case len(meta.Base64Variant) > 0 || meta.Base64Remapped > 0 || meta.Base64Dropped > 0 || meta.Base64BOM:
+		return DecodeRepaired
+	}
+
+	return DecodeOK
+
+}
+
+// pendingPart is a MIME part named by preparePart, whose data is still to be
+// decoded and written by writePart.
+type pendingPart struct {
+	meta PartMeta
+
+	// Failure to decode the part, if its Content-Transfer-Encoding isn't
+	// handled, or the reader decoding its data
+	err     error
+	decoder *stepReader
+
+	// With opts.WriteRaw, the data of the part, as read through raw
+	raw  *rawWriter
+	body io.Reader
+
+	// Set for a format=flowed text/plain part, see flowedParams()
+	flowed, delsp bool
+
+	// Set when there is nothing left to do for the part
+	done bool
+}
+
+// preparePart does the first step of extractPart, naming the part, which
+// has to be done in the order the parts are read.
+func (p *parser) preparePart(header textproto.MIMEHeader, body io.Reader, radix string, index int) *pendingPart {
+
+	// With opts.WriteRaw, the data of the part is also kept as is, before
+	// it is decoded
+	var raw *rawWriter
+	if p.opts.WriteRaw && !p.opts.NoDecode && !p.opts.DryRun && p.opts.OnPart == nil && p.opts.Archive == nil {
+		raw = &rawWriter{}
+		body = io.TeeReader(body, raw)
+	}
+
+	decoder, err := newDecoder(header, body)
+	if err == nil && decoder.base64 != nil {
+		decoder.base64.lenient = p.opts.LenientBase64
+	}
+
+	// With opts.NoDecode, the data is kept as received, whatever its
+	// Content-Transfer-Encoding, even an unknown one
+	if p.opts.NoDecode {
+		decoder, err = &stepReader{r: body, step: "reading MIME part data"}, nil
+	}
+
+	var filename string
+	if p.opts.NameFunc != nil {
+		filename = sanitizeFileName(p.opts.NameFunc(&multipart.Part{Header: header}, index))
+	}
+
+	// Otherwise, a part without a meaningful Content-Type is named upon the
+	// type sniffed from the start of its decoded data
+	if len(filename) == 0 {
+		filename = buildFileName(header, radix, index, p.opts.Extensions)
+		if err == nil && !p.opts.NoDecode && needsSniffing(header, p.opts.Extensions) {
+			// Nothing is sniffed from an empty part, nor from one which
+			// can't be decoded, which would be taken for text
+			buffered := bufio.NewReaderSize(decoder.r, sniffLen)
+			data, e := buffered.Peek(sniffLen)
+			if len(data) > 0 && (e == nil || e == io.EOF) {
+				if sniffed := sniffFileName(header, data, radix, index, p.opts.Extensions); len(sniffed) > 0 {
+					filename = sniffed
+				}
+			}
+			decoder.r = buffered
+		}
+
+		// Only the extension of the name is kept, after the sender and the
+		// date of the message and the number of the part
+		if p.opts.SenderDateNames {
+			filename = fmt.Sprintf("%s-%d%s", p.senderDate, p.count+1, path.Ext(filename))
+		}
+	}
+
+	if p.opts.portableNames() {
+		filename = portableFileName(filename)
+	}
+	name := filename
+
+	// The files of the parts kept encoded are named after their encoding,
+	// which opts.IncludeNames doesn't match
+	if encoding := transferEncoding(header); p.opts.NoDecode && (encoding == "base64" || encoding == "quoted-printable") {
+		filename += "." + encoding
+	}
+
+	position := positionString(p.position)
+	if p.opts.PositionalNames && len(position) > 0 {
+		filename = position + "-" + filename
+	}
+	filename = shortenFileName(filename)
+
+	meta := newPartMeta(header, p.uniqueName(filename))
+	meta.Name = declaredFileName(header)
+	if len(meta.Charset) == 0 && strings.HasPrefix(meta.ContentType, "text/") {
+		meta.Charset = p.opts.DefaultCharset
+	}
+	meta.Position = position
+	p.count++
+	meta.Index = p.count
+
+	if isSignature(meta.ContentType) {
+		p.signed = true
+	}
+	encrypted := p.inEncrypted > 0 || isEncrypted(header)
+	if encrypted {
+		p.encrypted = true
+	}
+
+	// The parts filtered out by the options aren't written
+	meta.Skipped = p.skip(meta, name) || (p.opts.SkipEncrypted && encrypted)
+
+	flowed, delsp := flowedParams(header)
+
+	return &pendingPart{
+		meta:    meta,
+		err:     err,
+		decoder: decoder,
+		raw:     raw,
+		body:    body,
+		flowed:  flowed,
+		delsp:   delsp,
+		done:    meta.Skipped,
+	}
+
+}
+
+// convertText returns a reader converting the decoded data of the text part
+// pending read from r, as asked by the options, such as opts.ConvertCharset.
+// R is returned as is for the other parts, or if there is nothing to do.
+func (p *parser) convertText(pending *pendingPart, r io.Reader) io.Reader {
+
+	meta := pending.meta
+
+	if p.opts.ConvertCharset && strings.HasPrefix(meta.ContentType, "text/") {
+		r = charsetReader(meta.Charset, r)
+	}
+
+	charset := meta.Charset
+	if p.opts.ConvertCharset {
+		charset = "utf-8"
+	}
+
+	if p.opts.Unflow && pending.flowed && singleByteLines(charset) {
+		r = newFlowedReader(pending.delsp, r)
+	}
+
+	if p.opts.SanitizeHTML && meta.ContentType == "text/html" && singleByteLines(charset) {
+		r = newHTMLSanitizer(r)
+	}
+
+	if len(p.opts.NewLine) > 0 && strings.HasPrefix(meta.ContentType, "text/") {
+		r = newlineReader(charset, p.opts.NewLine, r)
+	}
+
+	return r
+
+}
+
+// writePart does the second step of extractPart, decoding and writing the
+// part named by preparePart.
+func (p *parser) writePart(pending *pendingPart) (PartMeta, error) {
+
+	meta, decoder, raw, body := pending.meta, pending.decoder, pending.raw, pending.body
+	if pending.done {
+		return meta, nil
+	}
+
+	// The part can't be bigger than opts.MaxPartSize, nor than what is
+	// left of opts.MaxTotalBytes
+	limit := int64(-1)
+	if p.opts.MaxPartSize > 0 {
+		limit = p.opts.MaxPartSize
+	}
+	total_limit := false
+	if p.opts.MaxTotalBytes > 0 {
+		p.mu.Lock()
+		left := p.opts.MaxTotalBytes - p.written
+		p.mu.Unlock()
+		if limit < 0 || left < limit {
+			limit, total_limit = left, true
+		}
+	}
+	if meta.Skipped {
+		limit, total_limit = -1, false
+	}
+
+	if pending.err != nil {
+		meta.DecodeStatus = DecodeFailed
+		err := error(&DecodeError{Filename: meta.Filename, Err: pending.err})
+		// The raw data is all there is of a part which can't be decoded
+		if raw != nil && !meta.Skipped {
+			raw_file, e := p.openRaw(&meta, raw)
+			if e == nil {
+				e = closeRaw(p.opts.fileSystem(), raw_file, raw, body)
+			}
+			if e != nil {
+				err = errors.Join(err, e)
+			}
+		}
+		return meta, err
+	}
+
+	var err error
+
+	// Reading and decoding the part is abandoned once opts.PartTimeout is
+	// exceeded, even once its data is read in memory with opts.Workers
+	if p.opts.PartTimeout > 0 {
+		deadline := newPartDeadline(p.opts.PartTimeout)
+		defer deadline.stop()
+		decoder.r = &timeoutReader{ctx: p.ctx, deadline: deadline, r: decoder.r}
+	}
+
+	// The data kept as received with opts.NoDecode isn't converted either
+	if !p.opts.NoDecode {
+		decoder.r = p.convertText(pending, decoder.r)
+		meta.converted = p.opts.ConvertCharset && strings.HasPrefix(meta.ContentType, "text/")
+	}
+
+	// Only the start of the part is kept with opts.MaxBytesPerPart
+	var truncating *truncatingReader
+	if p.opts.MaxBytesPerPart > 0 {
+		truncating = &truncatingReader{r: decoder.r, left: p.opts.MaxBytesPerPart}
+		decoder.r = truncating
+	}
+
+	// Stop copying the part as soon as the context is done
+	decoder.r = contextReader{p.ctx, decoder.r}
+
+	// The checksum is computed on the data as it is written
+	hash := sha256.New()
+	decoder.r = io.TeeReader(decoder.r, hash)
+
+	// The decoded text of the body of the message is kept in memory as it
+	// is written with opts.KeepTextBody, for TextBody() and HTMLBody(), and
+	// so are the attachments with opts.KeepAttachments, for Attachments()
+	var content bytes.Buffer
+	keep := (p.opts.KeepTextBody && !p.opts.DryRun && meta.isTextBody()) || (p.opts.KeepAttachments && meta.Attachment)
+	keep = keep && !meta.Skipped
+	if keep {
+		decoder.r = io.TeeReader(decoder.r, &content)
+	}
+
+	var written int64
+	if p.opts.DryRun || meta.Skipped {
+		written, err = copyPart(io.Discard, decoder, meta.Filename, limit)
+	} else if p.opts.OnPart != nil {
+		written, err = p.handPart(meta, decoder, limit)
+	} else if p.opts.Archive != nil {
+		written, err = p.opts.Archive.writeEntry(p.opts.entryName(meta.Filename), decoder, limit, p.opts.fileMode(), entryDate(p.date))
+	} else {
+		filename, e := p.opts.outputPath(meta.Filename)
+		if e != nil {
+			return meta, &WriteError{Path: meta.Filename, Err: e}
+		}
+		fsys := p.opts.fileSystem()
+		var raw_file io.WriteCloser
+		if raw != nil && !meta.Skipped {
+			if raw_file, e = p.openRaw(&meta, raw); e != nil {
+				return meta, e
+			}
+		}
+		written, err = writeDecoded(fsys, decoder, filename, limit, p.opts.fileMode())
+		if raw_file != nil {
+			if e := closeRaw(fsys, raw_file, raw, body); err == nil {
+				err = e
+			}
+		}
+		if err == nil && p.opts.FileMode != 0 {
+			// Set the permissions asked for whatever the umask
+			if err = fsys.Chmod(filename, p.opts.FileMode); err != nil {
+				err = fmt.Errorf("setting the permissions of %q: %w", filename, err)
+			}
+		}
+		if err == nil && p.opts.PreserveDate && !p.date.IsZero() {
+			if err = fsys.Chtimes(filename, p.date, p.date); err != nil {
+				err = fmt.Errorf("setting the modification time of %q: %w", filename, err)
+			}
+		}
+	}
+
+	if err == nil && keep {
+		meta.content = content.Bytes()
+	}
+
+	if truncating != nil {
+		meta.Truncated = truncating.truncated
+	}
+	if decoder.base64 != nil {
+		meta.Base64Variant = decoder.base64.variant()
+		meta.Base64Remapped = decoder.base64.remapped
+		meta.Base64Dropped = decoder.base64.dropped
+		meta.Base64BOM = decoder.base64.bom > 0
+	}
+	meta.DecodeStatus = p.decodeStatus(meta, err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !meta.Skipped {
+		meta.Written = written
+		p.written += written
+		if err == nil {
+			meta.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+	if err == nil && !meta.Skipped && !p.opts.DryRun && p.opts.OnPart == nil {
+		p.logger(Normal).Printf("%s (%s, %d bytes)\n", meta.Filename, meta.ContentType, written)
+	}
+	if errors.Is(err, ErrTooLarge) && total_limit {
+		p.full = true
+		err = fmt.Errorf("stopping the extraction after %d bytes: %w", p.written, err)
+	}
+
+	return meta, err
+
+}
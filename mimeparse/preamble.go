@@ -0,0 +1,126 @@
+package mimeparse
+
+import (
+	"bytes"
+)
+
+// MaxPreambleLen is the maximum number of bytes kept for the preamble, and
+// for the epilogue, of a multipart message with Options.KeepPreamble. Only
+// their start is kept beyond, see Message.PreambleTruncated.
+const MaxPreambleLen = 64 * 1024
+
+// preambleRecorder records the preamble and the epilogue of a multipart body
+// written to it, as read by the multipart.Reader, which discards them: the
+// data before the line of the first delimiter, "--" and the boundary, and the
+// data after the line of the closing delimiter, "--" and the boundary
+// followed by "--", see Options.KeepPreamble. Only a few bytes are held in
+// between, to find the delimiters across writes, and at most MaxPreambleLen
+// bytes are kept for each of the preamble and the epilogue.
+type preambleRecorder struct {
+	delimiter []byte
+
+	// The end of the data recorded so far, which may start a delimiter,
+	// and whether some data was already moved from it to the preamble
+	data  []byte
+	moved bool
+
+	preamble, epilogue []byte
+
+	// Set once the first delimiter is found, and once the closing one is
+	// found, along with the end of its line
+	started, closed, epilogueStarted bool
+
+	// Set when the preamble, or the epilogue, is longer than MaxPreambleLen
+	preambleTruncated, epilogueTruncated bool
+}
+
+func newPreambleRecorder(boundary string) *preambleRecorder {
+	return &preambleRecorder{delimiter: []byte("--" + boundary)}
+}
+
+func (r *preambleRecorder) Write(p []byte) (int, error) {
+
+	switch {
+
+	case r.epilogueStarted:
+		r.epilogue = appendCapped(r.epilogue, p, &r.epilogueTruncated)
+
+	case r.closed:
+		r.startEpilogue(p)
+
+	case !r.started:
+		r.data = append(r.data, p...)
+		start := 0
+		if !r.moved && bytes.HasPrefix(r.data, r.delimiter) {
+			r.started = true
+		} else if i := bytes.Index(r.data, append([]byte("\n"), r.delimiter...)); i >= 0 {
+			// The line break before the delimiter is part of the delimiter
+			r.preamble = appendCapped(r.preamble, bytes.TrimSuffix(r.data[:i], []byte("\r")), &r.preambleTruncated)
+			r.started, start = true, i
+		} else if keep := len(r.delimiter) + 2; len(r.data) > keep {
+			// Only the end of the data which may start the delimiter,
+			// along with its line break, is held
+			r.preamble = appendCapped(r.preamble, r.data[:len(r.data)-keep], &r.preambleTruncated)
+			r.data = append(r.data[:0], r.data[len(r.data)-keep:]...)
+			r.moved = true
+		}
+		if r.started {
+			data := r.data
+			r.data = nil
+			r.findClose(data[start:])
+		}
+
+	default:
+		r.findClose(p)
+
+	}
+
+	return len(p), nil
+
+}
+
+// appendCapped appends p to data, as long as data has less than
+// MaxPreambleLen bytes, setting truncated if some of p is left out.
+func appendCapped(data, p []byte, truncated *bool) []byte {
+
+	if left := MaxPreambleLen - len(data); len(p) > left {
+		p = p[:left]
+		*truncated = true
+	}
+
+	return append(data, p...)
+
+}
+
+// findClose looks for the closing delimiter in p, following the data held.
+func (r *preambleRecorder) findClose(p []byte) {
+
+	closing := append(append([]byte("\n"), r.delimiter...), '-', '-')
+	data := append(r.data, p...)
+
+	i := bytes.Index(data, closing)
+	if i < 0 {
+		// Only the end of the data which may start the delimiter is held
+		if len(data) > len(closing) {
+			data = data[len(data)-len(closing):]
+		}
+		r.data = append([]byte(nil), data...)
+		return
+	}
+
+	r.data = nil
+	r.closed = true
+	r.startEpilogue(data[i+len(closing):])
+
+}
+
+// startEpilogue skips the end of the line of the closing delimiter in p, the
+// rest being the start of the epilogue.
+func (r *preambleRecorder) startEpilogue(p []byte) {
+
+	if i := bytes.IndexByte(p, '\n'); i >= 0 {
+		r.epilogueStarted = true
+		r.epilogue = appendCapped(r.epilogue, p[i+1:], &r.epilogueTruncated)
+	}
+
+}
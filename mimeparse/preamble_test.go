@@ -0,0 +1,65 @@
+package mimeparse
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestKeepPreamble(t *testing.T) {
+
+	header := "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n"
+	part := "--XX\r\nContent-Type: text/plain\r\n\r\nhello\r\n"
+	long := strings.Repeat("x", MaxPreambleLen+10)
+
+	tests := []struct {
+		name                     string
+		data                     string
+		preamble, epilogue       string
+		preambleCut, epilogueCut bool
+	}{
+		{"none", header + part + "--XX--\r\n", "", "", false, false},
+		{"both", header + "This is MIME.\r\n" + part + "--XX--\r\nThe end.\r\n", "This is MIME.", "The end.\r\n", false, false},
+		{"long", header + long + "\r\n" + part + "--XX--\r\n" + long, long[:MaxPreambleLen], long[:MaxPreambleLen], true, true},
+		{"no boundary", header + long, long[:MaxPreambleLen], "", true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			// The data is written to the recorder at once, and one byte
+			// at a time
+			for _, r := range []io.Reader{strings.NewReader(test.data), &oneByteReader{strings.NewReader(test.data)}} {
+				m, _ := ParseEmail(r, Options{DryRun: true, KeepPreamble: true})
+				if m == nil {
+					t.Fatal("no message")
+				}
+				if string(m.Preamble) != test.preamble || m.PreambleTruncated != test.preambleCut {
+					t.Errorf("got preamble %.20q of %d bytes, truncated %v, want %.20q of %d bytes, truncated %v",
+						m.Preamble, len(m.Preamble), m.PreambleTruncated, test.preamble, len(test.preamble), test.preambleCut)
+				}
+				if string(m.Epilogue) != test.epilogue || m.EpilogueTruncated != test.epilogueCut {
+					t.Errorf("got epilogue %.20q of %d bytes, truncated %v, want %.20q of %d bytes, truncated %v",
+						m.Epilogue, len(m.Epilogue), m.EpilogueTruncated, test.epilogue, len(test.epilogue), test.epilogueCut)
+				}
+			}
+
+		})
+	}
+
+}
+
+// oneByteReader reads from r one byte at a time.
+type oneByteReader struct {
+	r *strings.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	return o.r.Read(p[:1])
+
+}
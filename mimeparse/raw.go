@@ -0,0 +1,82 @@
+package mimeparse
+
+import (
+	"bytes"
+	"io"
+)
+
+// RawSuffix is appended to the name of the file of a part to name the file
+// where its raw data is written with Options.WriteRaw.
+const RawSuffix = ".raw"
+
+// rawWriter receives the raw data of a part, as read before it is decoded.
+// The data is held in memory until the file it is written to is opened, once
+// the part is named. A failure to write the data doesn't prevent the part
+// from being decoded, it is only remembered.
+type rawWriter struct {
+	pending bytes.Buffer
+	w       io.Writer
+	err     error
+
+	// Path of the file the data is written to, once opened
+	path string
+}
+
+func (r *rawWriter) Write(p []byte) (int, error) {
+
+	if r.w == nil {
+		return r.pending.Write(p)
+	}
+
+	if r.err == nil {
+		_, r.err = r.w.Write(p)
+	}
+
+	return len(p), nil
+
+}
+
+// openRaw creates the file where the raw data of the part described by meta
+// is written, setting meta.RawFilename, and writes to it the data received
+// by raw so far.
+func (p *parser) openRaw(meta *PartMeta, raw *rawWriter) (io.WriteCloser, error) {
+
+	meta.RawFilename = p.uniqueName(meta.Filename + RawSuffix)
+
+	filename, err := p.opts.outputPath(meta.RawFilename)
+	if err != nil {
+		return nil, &WriteError{Path: meta.RawFilename, Err: err}
+	}
+
+	file, err := p.opts.fileSystem().Create(filename, p.opts.fileMode())
+	if err != nil {
+		return nil, &WriteError{Path: filename, Err: err}
+	}
+
+	raw.w, raw.path = file, filename
+	_, raw.err = raw.pending.WriteTo(file)
+
+	return file, nil
+
+}
+
+// closeRaw completes the raw data written to file, reading what is left of
+// body, the part read through raw, as the decoding may stop before its end.
+// The file is removed from fsys if the data couldn't be entirely written.
+func closeRaw(fsys FS, file io.WriteCloser, raw *rawWriter, body io.Reader) error {
+
+	io.Copy(io.Discard, body)
+
+	err := raw.err
+	if e := file.Close(); err == nil {
+		err = e
+	}
+
+	if err != nil {
+		fsys.Remove(raw.path)
+		return &WriteError{Path: raw.path, Err: err}
+	}
+
+	return nil
+
+}
@@ -0,0 +1,66 @@
+package mimeparse
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// With WriteRaw, the raw data of a part is written along with its decoded data.
+func TestWriteRaw(t *testing.T) {
+
+	dir := t.TempDir()
+	m, err := ParseEmail(bytes.NewReader(readFixture(t, "base64.eml")), Options{OutputDir: dir, WriteRaw: true, Verbosity: Quiet})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	part := m.Parts[0]
+	decoded, err := os.ReadFile(filepath.Join(dir, part.Filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, part.RawFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "Un pixel joint, voilà.\n" || string(raw) != "VW4gcGl4ZWwgam9pbnQsIHZvaWzDoC4K" {
+		t.Errorf("got decoded %q and raw %q", decoded, raw)
+	}
+
+}
+
+// The raw data of a part with an unknown Content-Transfer-Encoding is written
+// all the same, as it can't be decoded.
+func TestWriteRawUnknownEncoding(t *testing.T) {
+
+	message := "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n" +
+		"--XX\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"data.bin\"\r\n" +
+		"Content-Transfer-Encoding: x-uuencode\r\n\r\nbegin 644 data.bin\r\nend\r\n" +
+		"--XX\r\nContent-Type: text/plain\r\n\r\nhello\r\n--XX--\r\n"
+
+	dir := t.TempDir()
+	m, err := ParseEmail(strings.NewReader(message), Options{OutputDir: dir, WriteRaw: true, Verbosity: Quiet})
+	var decode_error *DecodeError
+	if !errors.As(err, &decode_error) {
+		t.Fatalf("got error %v, want a DecodeError", err)
+	}
+
+	if m.Parts[0].RawFilename != "data.bin.raw" {
+		t.Fatalf("got raw file %q, want data.bin.raw", m.Parts[0].RawFilename)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "data.bin.raw"))
+	if err != nil || string(raw) != "begin 644 data.bin\r\nend" {
+		t.Errorf("got raw data %q, %v, want %q", raw, err, "begin 644 data.bin\r\nend")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data.bin")); !os.IsNotExist(err) {
+		t.Errorf("the part which can't be decoded is written")
+	}
+	if len(m.Parts) != 2 || len(m.Parts[1].SHA256) == 0 {
+		t.Errorf("the next part isn't extracted: %+v", m.Parts)
+	}
+
+}
@@ -0,0 +1,212 @@
+package mimeparse
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// droppedElements are the HTML elements removed by htmlSanitizer, along with
+// their content, as they run code or load remote content.
+var droppedElements = map[string]bool{
+	"applet":   true,
+	"base":     true,
+	"embed":    true,
+	"frame":    true,
+	"frameset": true,
+	"iframe":   true,
+	"link":     true,
+	"meta":     true,
+	"noscript": true,
+	"object":   true,
+	"script":   true,
+}
+
+// urlAttributes are the HTML attributes holding a URL, which htmlSanitizer
+// removes if the URL is remote, see isLocalURL.
+var urlAttributes = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"data":       true,
+	"dynsrc":     true,
+	"formaction": true,
+	"href":       true,
+	"longdesc":   true,
+	"lowsrc":     true,
+	"poster":     true,
+	"src":        true,
+	"srcset":     true,
+	"xlink:href": true,
+}
+
+// htmlSanitizer removes from the HTML read from r what could run code, or
+// reveal that the message is read by loading remote content, see
+// Options.SanitizeHTML: the droppedElements, the on* event handlers, the
+// urlAttributes with a remote URL, and the style attributes and the text of
+// the style elements loading URLs, see unsafeCSS. The comments are removed too, as some of them are interpreted by mailers.
+// The rest of the HTML is kept as it was read.
+type htmlSanitizer struct {
+	tokenizer *html.Tokenizer
+
+	// The sanitized HTML not read yet
+	out bytes.Buffer
+
+	// Name of the dropped element whose content is being skipped, if any
+	skipping string
+
+	// Set within a style element
+	style bool
+
+	err error
+}
+
+func newHTMLSanitizer(r io.Reader) io.Reader {
+	return &htmlSanitizer{tokenizer: html.NewTokenizer(r)}
+}
+
+func (s *htmlSanitizer) Read(p []byte) (int, error) {
+
+	for s.out.Len() == 0 && s.err == nil {
+		s.next()
+	}
+
+	if s.out.Len() > 0 {
+		return s.out.Read(p)
+	}
+
+	return 0, s.err
+
+}
+
+// next sanitizes the next token of the HTML.
+func (s *htmlSanitizer) next() {
+
+	kind := s.tokenizer.Next()
+	if kind == html.ErrorToken {
+		s.err = s.tokenizer.Err()
+		return
+	}
+
+	// The token is kept as it was read, unless it has to be changed. Its raw
+	// data is copied first, as Token() unescapes it in place. The data of a
+	// tag is the name of its element.
+	kept := s.out.Len()
+	s.out.Write(s.tokenizer.Raw())
+	token := s.tokenizer.Token()
+	element := token.Data
+
+	if len(s.skipping) > 0 {
+		if kind == html.EndTagToken && element == s.skipping {
+			s.skipping = ""
+		}
+		s.out.Truncate(kept)
+		return
+	}
+
+	switch kind {
+
+	case html.CommentToken:
+		s.out.Truncate(kept)
+
+	case html.TextToken:
+		// A style sheet loading anything is dropped as a whole
+		if s.style && unsafeCSS(token.Data) {
+			s.out.Truncate(kept)
+		}
+
+	case html.StartTagToken, html.SelfClosingTagToken:
+		s.style = element == "style" && kind == html.StartTagToken
+		if droppedElements[element] {
+			if kind == html.StartTagToken && !voidElements[element] {
+				s.skipping = element
+			}
+			s.out.Truncate(kept)
+		} else if sanitizeAttributes(&token) {
+			s.out.Truncate(kept)
+			s.out.WriteString(token.String())
+		}
+
+	case html.EndTagToken:
+		s.style = false
+		if droppedElements[element] {
+			s.out.Truncate(kept)
+		}
+
+	}
+
+}
+
+// voidElements are the droppedElements which have no content, nor end tag.
+var voidElements = map[string]bool{
+	"base":  true,
+	"embed": true,
+	"link":  true,
+	"meta":  true,
+}
+
+// sanitizeAttributes removes the attributes of token which htmlSanitizer
+// doesn't keep, and reports whether there were any.
+func sanitizeAttributes(token *html.Token) bool {
+
+	kept := token.Attr[:0]
+	for _, attr := range token.Attr {
+		key := strings.ToLower(attr.Key)
+		if len(attr.Namespace) > 0 {
+			key = strings.ToLower(attr.Namespace) + ":" + key
+		}
+		switch {
+		case strings.HasPrefix(key, "on"):
+		case urlAttributes[key] && !isLocalURL(attr.Val):
+		case key == "style" && unsafeCSS(attr.Val):
+		default:
+			kept = append(kept, attr)
+		}
+	}
+
+	removed := len(kept) < len(token.Attr)
+	token.Attr = kept
+
+	return removed
+
+}
+
+// unsafeCSS reports whether the CSS css may load something, or run code: it
+// has a url(), an image-set() or an expression(), or an @import rule. The CSS
+// escapes, which could hide them, such as in "u\72l(", make it unsafe too.
+func unsafeCSS(css string) bool {
+
+	css = strings.ToLower(css)
+
+	return strings.Contains(css, "url(") || strings.Contains(css, "image-set(") || strings.Contains(css, "expression(") ||
+		strings.Contains(css, "@import") || strings.Contains(css, `\`)
+
+}
+
+// isLocalURL reports whether url doesn't load anything from outside of the
+// message: a reference to another part ("cid:"), an anchor, a relative path,
+// an email address, or an image embedded as a "data:" URL.
+func isLocalURL(url string) bool {
+
+	url = strings.ToLower(strings.TrimSpace(url))
+	if strings.HasPrefix(url, "//") || strings.HasPrefix(url, `\\`) {
+		return false
+	}
+
+	scheme, _, found := strings.Cut(url, ":")
+	if !found || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+
+	switch scheme {
+	case "cid", "mailto":
+		return true
+	case "data":
+		return strings.HasPrefix(url, "data:image/")
+	}
+
+	return false
+
+}
@@ -0,0 +1,35 @@
+package mimeparse
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+
+	tests := []struct {
+		html, want string
+	}{
+		{`<p>Hi</p><script>alert(1)</script>`, `<p>Hi</p>`},
+		{`<img src="http://evil/x.png"><img src="cid:logo">`, `<img><img src="cid:logo">`},
+		{`<a href="#top" onclick="x()">top</a>`, `<a href="#top">top</a>`},
+		{`<p style="background:url(http://evil)">x</p>`, `<p>x</p>`},
+		{`<style>@import url(http://evil); body{background:url(http://evil)}</style><p>x</p>`, `<style></style><p>x</p>`},
+		{`<style>body{background:u\72l(http://evil)}</style>`, `<style></style>`},
+		{`<style>p{color:red}</style><p>x</p>`, `<style>p{color:red}</style><p>x</p>`},
+		{`<p>url(http://example.com) is text</p>`, `<p>url(http://example.com) is text</p>`},
+		{`<!-- [if mso]> --><p>x</p>`, `<p>x</p>`},
+	}
+
+	for _, test := range tests {
+		got, err := io.ReadAll(newHTMLSanitizer(strings.NewReader(test.html)))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.html, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%q: got %q, want %q", test.html, got, test.want)
+		}
+	}
+
+}
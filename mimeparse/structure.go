@@ -0,0 +1,245 @@
+package mimeparse
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// PartNode is a node of the tree of the MIME parts of a message, as built by
+// ParseStructure.
+type PartNode struct {
+
+	// Position is the position of the part in the tree, as for
+	// PartMeta.Position. It is empty for the message itself, the root of
+	// the tree.
+	Position string `json:"position"`
+
+	// ContentType is the media type of the part, without its parameters,
+	// and Boundary the boundary of a multipart part.
+	ContentType string `json:"content_type"`
+	Boundary    string `json:"boundary,omitempty"`
+
+	// ContentTransferEncoding is the Content-Transfer-Encoding of the part,
+	// in lower case, or empty if there is none.
+	ContentTransferEncoding string `json:"content_transfer_encoding,omitempty"`
+
+	// Filename is the file name given to the part by the message, if any.
+	Filename string `json:"filename,omitempty"`
+
+	// Size is the number of bytes of the part once decoded, for the parts
+	// which aren't multipart.
+	Size int64 `json:"size"`
+
+	// Error is the error met while going through the part, if any.
+	Error string `json:"error,omitempty"`
+
+	// Children are the parts of a multipart part, in the order they were
+	// read, or the message of a message/rfc822 part.
+	Children []*PartNode `json:"children,omitempty"`
+}
+
+// ParseStructure reads a MIME email from r and returns the tree of its MIME
+// parts, the message itself being the root of the tree, without writing
+// anything. Each part is decoded to know its size. The errors met are
+// recorded in the nodes of the tree, and returned together along with it,
+// unless r can't be read as an email at all. Of the options, only
+// opts.MaxDepth is used: the parts nested deeper are recorded with an error,
+// without their own parts.
+func ParseStructure(r io.Reader, opts Options) (*PartNode, error) {
+
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing mail: %w: %w", ErrNotMIME, err)
+	}
+
+	var errs []error
+	root := structureNode(textproto.MIMEHeader(m.Header), m.Body, nil, 1, opts.maxDepth(), &errs)
+
+	return root, errors.Join(errs...)
+
+}
+
+// structureNode builds the node of the part described by header, whose data
+// is read from body, at position in the tree, and at the level depth, along
+// with the nodes of its own parts, down to the level max_depth. The errors met
+// are appended to errs.
+func structureNode(header textproto.MIMEHeader, body io.Reader, position []int, depth, max_depth int, errs *[]error) *PartNode {
+
+	node := &PartNode{
+		Position:                positionString(position),
+		ContentTransferEncoding: transferEncoding(header),
+	}
+
+	failed := func(err error) *PartNode {
+		node.Error = err.Error()
+		*errs = append(*errs, fmt.Errorf("part %q: %w", node.Position, err))
+		return node
+	}
+
+	mediaType, params, _ := parseContentType(header)
+	node.ContentType = mediaType
+
+	if filename := dispositionFileName(header.Get("Content-Disposition")); len(filename) > 0 {
+		node.Filename, _ = DecodeHeader(filename)
+	}
+
+	if depth > max_depth {
+		return failed(fmt.Errorf("more than %d nested levels", max_depth))
+	}
+
+	switch {
+
+	case strings.HasPrefix(mediaType, "multipart/"):
+		node.Boundary = params["boundary"]
+		if len(node.Boundary) == 0 {
+			return failed(ErrNoBoundary)
+		}
+		closing := newClosingReader(body, node.Boundary)
+		reader := multipart.NewReader(closing, node.Boundary)
+		for i := 1; ; i++ {
+			part, err := nextRawPart(reader, closing)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return failed(err)
+			}
+			if mediaType == "multipart/digest" && len(strings.TrimSpace(part.Header.Get("Content-Type"))) == 0 {
+				part.Header.Set("Content-Type", "message/rfc822")
+			}
+			child := structureNode(part.Header, part, append(position[:len(position):len(position)], i), depth+1, max_depth, errs)
+			node.Children = append(node.Children, child)
+		}
+
+	case mediaType == "message/rfc822":
+		decoder, err := newDecoder(header, body)
+		if err != nil {
+			return failed(err)
+		}
+		counter := &countingReader{r: decoder}
+		m, err := mail.ReadMessage(counter)
+		if err != nil {
+			return failed(fmt.Errorf("%w: %w", ErrNotMIME, err))
+		}
+		child := structureNode(textproto.MIMEHeader(m.Header), m.Body, position, depth+1, max_depth, errs)
+		child.Position = node.Position
+		node.Children = append(node.Children, child)
+		io.Copy(io.Discard, counter)
+		node.Size = counter.n
+
+	default:
+		decoder, err := newDecoder(header, body)
+		if err != nil {
+			return failed(err)
+		}
+		node.Size, err = io.Copy(io.Discard, decoder)
+		if err != nil {
+			return failed(err)
+		}
+
+	}
+
+	return node
+
+}
+
+// CountAttachments reads a MIME email from r and returns its number of
+// attachments, the parts which aren't multipart and would be extracted with
+// PartMeta.Attachment set, without writing nor decoding anything, as a quick
+// alternative to ParseEmail with DryRun. The attached messages are counted as
+// attachments, not walked through. The attachments counted up to the first
+// error met, if any, are returned along with it. Of the options, only
+// opts.MaxDepth and opts.MaxParts are used, the count failing as the
+// extraction would.
+func CountAttachments(r io.Reader, opts Options) (int, error) {
+
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return 0, fmt.Errorf("parsing mail: %w: %w", ErrNotMIME, err)
+	}
+
+	parts := 0
+
+	return countAttachments(textproto.MIMEHeader(m.Header), m.Body, 1, opts, &parts)
+
+}
+
+// countAttachments does the job of CountAttachments for the part described
+// by header, whose data is read from body, at the level depth. Parts is the
+// number of parts which aren't multipart met so far, for opts.MaxParts.
+func countAttachments(header textproto.MIMEHeader, body io.Reader, depth int, opts Options, parts *int) (int, error) {
+
+	mediaType, params, err := parseContentType(header)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		if opts.MaxParts > 0 && *parts >= opts.MaxParts {
+			return 0, fmt.Errorf("stopping the count after %d parts: %w", *parts, ErrTooManyParts)
+		}
+		*parts++
+		if newPartMeta(header, "").Attachment {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if depth > opts.maxDepth() {
+		return 0, fmt.Errorf("more than %d nested levels", opts.maxDepth())
+	}
+	if len(params["boundary"]) == 0 {
+		return 0, ErrNoBoundary
+	}
+
+	count := 0
+	closing := newClosingReader(body, params["boundary"])
+	reader := multipart.NewReader(closing, params["boundary"])
+	for {
+		part, err := nextRawPart(reader, closing)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if mediaType == "multipart/digest" && len(strings.TrimSpace(part.Header.Get("Content-Type"))) == 0 {
+			part.Header.Set("Content-Type", "message/rfc822")
+		}
+		n, err := countAttachments(part.Header, part, depth+1, opts, parts)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+
+}
+
+// positionString returns position as a string, its numbers separated by dots.
+func positionString(position []int) string {
+
+	numbers := make([]string, len(position))
+	for i, n := range position {
+		numbers[i] = strconv.Itoa(n)
+	}
+
+	return strings.Join(numbers, ".")
+
+}
@@ -0,0 +1,134 @@
+package mimeparse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The tree of the MIME parts stops at MaxDepth, the part nested deeper being
+// recorded with an error, without its own parts.
+func TestParseStructureMaxDepth(t *testing.T) {
+
+	tests := []struct {
+		depth    int
+		maxDepth int
+		levels   int
+	}{
+		{5, 0, 6},
+		{5, 3, 4},
+		{DefaultMaxDepth + 5, 0, DefaultMaxDepth + 1},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%d/%d", test.depth, test.maxDepth), func(t *testing.T) {
+
+			root, err := ParseStructure(strings.NewReader(nestedMessage(test.depth)), Options{MaxDepth: test.maxDepth})
+			if (err != nil) != (test.levels <= test.depth) {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			levels := 1
+			node := root
+			for len(node.Children) > 0 {
+				node = node.Children[0]
+				levels++
+			}
+			if levels != test.levels {
+				t.Errorf("got %d levels, want %d", levels, test.levels)
+			}
+			if test.levels <= test.depth && !strings.Contains(node.Error, "nested levels") {
+				t.Errorf("got error %q for the deepest part, want the nested levels exceeded", node.Error)
+			}
+
+		})
+	}
+
+}
+
+// The attachments are counted as ParseEmail would extract them.
+func TestCountAttachments(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		message string
+		want    int
+	}{
+		{"invoices", invoiceMessage, 3},
+		{"nested", filterMessage, 3},
+		{"attached message", string(readFixture(t, "nested.eml")), 1},
+		{"base64", string(readFixture(t, "base64.eml")), 1},
+		{"single part", singlePartMessage("application/pdf", "base64", "JVBERi0=\r\n"), 1},
+		{"text", singlePartMessage("text/plain", "7bit", "Hello\r\n"), 0},
+		{"many", string(attachmentsMessage(50, 10)), 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			count, err := CountAttachments(strings.NewReader(test.message), Options{})
+			if err != nil || count != test.want {
+				t.Errorf("got %d, %v, want %d", count, err, test.want)
+			}
+
+			m, err := ParseEmail(strings.NewReader(test.message), Options{DryRun: true})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			attachments := 0
+			for _, part := range m.Parts {
+				if part.Attachment {
+					attachments++
+				}
+			}
+			if attachments != count {
+				t.Errorf("got %d attachments extracted, %d counted", attachments, count)
+			}
+
+		})
+	}
+
+	if _, err := CountAttachments(bytes.NewReader(nil), Options{}); err == nil {
+		t.Error("got no error for an empty message")
+	}
+
+}
+
+// The count of the attachments fails as the extraction would past MaxDepth
+// and MaxParts, with the attachments counted so far.
+func TestCountAttachmentsLimits(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		message string
+		opts    Options
+		want    int
+		err     string
+	}{
+		{"depth", nestedMessage(5), Options{}, 0, ""},
+		{"max depth", nestedMessage(5), Options{MaxDepth: 3}, 0, "more than 3 nested levels"},
+		{"default max depth", nestedMessage(DefaultMaxDepth + 5), Options{}, 0, "nested levels"},
+		{"parts", string(attachmentsMessage(10, 10)), Options{MaxParts: 10}, 10, ""},
+		{"max parts", string(attachmentsMessage(10, 10)), Options{MaxParts: 4}, 4, ErrTooManyParts.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			count, err := CountAttachments(strings.NewReader(test.message), test.opts)
+			if count != test.want {
+				t.Errorf("got %d attachments, want %d", count, test.want)
+			}
+			if len(test.err) == 0 && err != nil || len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+			if test.opts.MaxParts > 0 && len(test.err) > 0 && !errors.Is(err, ErrTooManyParts) {
+				t.Errorf("got error %v, want ErrTooManyParts", err)
+			}
+
+		})
+	}
+
+}
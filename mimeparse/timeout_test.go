@@ -0,0 +1,79 @@
+package mimeparse
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stalledReader reads data, then blocks until stop is closed, as a stalled
+// connection would.
+type stalledReader struct {
+	data io.Reader
+	stop chan struct{}
+}
+
+func (s stalledReader) Read(p []byte) (int, error) {
+
+	if n, err := s.data.Read(p); err != io.EOF {
+		return n, err
+	}
+	<-s.stop
+
+	return 0, io.EOF
+
+}
+
+// A stalled part is abandoned once PartTimeout is exceeded, whatever is being
+// read when it stalls.
+func TestPartTimeout(t *testing.T) {
+
+	header := "From: alice@example.com\r\nContent-Type: multipart/mixed; boundary=XX\r\n\r\n"
+	tests := []struct {
+		name string
+		data string
+		opts Options
+	}{
+		{"message header", "From: alice@example.com\r\nSubj", Options{}},
+		{"part header", header + "--XX\r\nContent-Type: text/pl", Options{}},
+		{"part data", header + "--XX\r\nContent-Type: text/plain\r\n\r\nhel", Options{}},
+		{"sniffed part", header + "--XX\r\nContent-Type: application/octet-stream\r\n\r\n%PD", Options{}},
+		{"sniffed message", header + "--XX\r\nContent-Type: application/octet-stream\r\n\r\nFrom: b", Options{SniffMessages: true}},
+		{"skipped part", header + "--XX\r\nContent-Type: application/pdf\r\n\r\n%PD", Options{ExcludeTypes: []string{"application/*"}}},
+		{"workers", header + "--XX\r\nContent-Type: text/plain\r\n\r\nhel", Options{Workers: 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+
+			stop := make(chan struct{})
+			defer close(stop)
+
+			test.opts.DryRun, test.opts.PartTimeout = true, 50*time.Millisecond
+			done := make(chan error, 1)
+			go func() {
+				_, err := ParseEmail(stalledReader{strings.NewReader(test.data), stop}, test.opts)
+				done <- err
+			}()
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, ErrPartTimeout) {
+					t.Errorf("got error %v, want ErrPartTimeout", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the part timeout wasn't applied")
+			}
+
+		})
+	}
+
+	// The parts read in time are extracted as usual
+	parts, _, err := parseFixture(t, "nested.eml", Options{PartTimeout: time.Second, ParseAttachedMessages: true})
+	if err != nil || len(parts) != 3 {
+		t.Errorf("got %d parts, %v, want 3 parts", len(parts), err)
+	}
+
+}
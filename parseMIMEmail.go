@@ -1,172 +1,531 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/base64"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"mime"
-	"mime/multipart"
-	"mime/quotedprintable"
-	"net/mail"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"text/tabwriter"
+
+	"github.com/kirabou/parseMIMEemail/mimeparse"
 )
 
+// mbox is set to read mailboxes in the mbox format rather than single emails.
+var mbox bool
+
+// quiet is set with -q, or when the standard output receives the archive of
+// the MIME parts, or the data of a single part, so nothing else is written to it.
+var quiet bool
+
+// list is set with -list to display a table of the MIME parts of each
+// message rather than extracting them.
+var list bool
+
+// verbose is set with -v to display the tree of the MIME parts of each
+// message, as JSON.
+var verbose bool
+
+// events is set with -json to write the description of the messages and of
+// their MIME parts to stdout, as newline-delimited JSON, rather than their
+// main headers.
+var events bool
+
+// partIndex and partType select the single MIME part written to the standard
+// output with -part and -part-type.
+var (
+	partIndex int
+	partType  string
+)
+
+// Read MIME emails from the files given as arguments, or from stdio if there
+// are none, and explode their MIME parts into separated files, one for each
+// part.
+func main() {
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [file.eml ...]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(flag.CommandLine.Output(), "Reads stdin when no file is given. With several files, the MIME parts")
+		fmt.Fprintln(flag.CommandLine.Output(), "of each of them are written in a subdirectory named after the file.")
+		fmt.Fprintln(flag.CommandLine.Output(), "The input may be gzip compressed, such as a file.eml.gz.")
+		fmt.Fprintln(flag.CommandLine.Output())
+		flag.PrintDefaults()
+	}
+
+	// The files written, and the tree of the MIME parts with -v, are
+	// displayed on stderr, so stdout only receives the data asked for
+	opts := mimeparse.Options{Logger: log.New(os.Stderr, "", 0)}
+	flag.StringVar(&opts.OutputDir, "o", "", "directory where the MIME parts are written")
+	flag.BoolVar(&opts.PositionalNames, "pos", false, "prefix the file names with the position of the MIME parts, such as 1.2-photo.jpg")
+	flag.BoolVar(&opts.SenderDateNames, "sd", false, "name the files after the date and sender of the message, such as 20230102-john-doe-2.pdf")
+	flag.BoolVar(&opts.TempDir, "tmp", false, "write the MIME parts of each message to a new temporary directory, displayed with the headers")
+	flag.BoolVar(&opts.SubjectDir, "s", false, "write the MIME parts of each message to a subdirectory named after its subject")
+	flag.BoolVar(&opts.PortableNames, "win", false, "make the file names valid on Windows too (always done on Windows)")
+	flag.BoolVar(&opts.DryRun, "n", false, "list the MIME parts without writing them")
+	flag.BoolVar(&events, "json", false, "write a JSON object for each MIME part and each message to stdout, one per line, as they are parsed")
+	flag.BoolVar(&list, "list", false, "display a table of the MIME parts, with their type, disposition, name and size, without writing them")
+	flag.BoolVar(&opts.ConvertCharset, "u", false, "convert the text parts to UTF-8")
+	flag.StringVar(&opts.DefaultCharset, "charset", "", "charset of the text parts which don't declare any, such as iso-8859-1")
+	lf := flag.Bool("lf", false, "convert the line endings of the text parts to LF")
+	flag.BoolVar(&opts.SanitizeHTML, "safe-html", false, "remove the scripts and the remote references from the HTML parts")
+	flag.BoolVar(&opts.Unflow, "unflow", false, "join the soft-wrapped lines of the format=flowed text parts")
+	flag.Int64Var(&opts.MaxBytesPerPart, "head", 0, "only write the first N bytes of each MIME part")
+	flag.StringVar(&opts.Boundary, "boundary", "", "boundary of the MIME parts of the messages, whatever their Content-Type")
+	flag.BoolVar(&opts.ParseAttachedMessages, "r", false, "extract the MIME parts of the attached messages")
+	flag.BoolVar(&opts.SniffMessages, "sniff", false, "extract the MIME parts of the application/octet-stream parts which are actually multipart messages")
+	flag.BoolVar(&opts.SkipSignatures, "nosig", false, "don't extract the signature parts of the signed messages")
+	flag.BoolVar(&opts.SkipEncrypted, "noenc", false, "don't extract the encrypted parts, which can't be used without their keys")
+	flag.BoolVar(&opts.AttachmentsOnly, "a", false, "only extract the attachments, not the inline parts")
+	flag.BoolVar(&opts.KeepPreamble, "preamble", false, "display the preamble and the epilogue of the multipart messages, the text around their MIME parts")
+	flag.BoolVar(&opts.HeadersFile, "headers", false, "write a "+mimeparse.HeadersName+" with all the header fields of each message")
+	flag.BoolVar(&opts.Manifest, "m", false, "write a "+mimeparse.ManifestName+" describing the MIME parts")
+	include_types := flag.String("include", "", "comma-separated media types of the parts to extract, such as \"application/pdf,image/*\"")
+	exclude_types := flag.String("exclude", "", "comma-separated media types of the parts not to extract")
+	include_names := flag.String("names", "", "comma-separated file names of the parts to extract, such as \"*.pdf,report-*.xlsx\"")
+	flag.BoolVar(&opts.MatchAny, "any", false, "with both -include and -names, extract the parts matching either of them, rather than both")
+	flag.BoolVar(&opts.NoDecode, "nodecode", false, "write the MIME parts as received, without decoding them from base64 or quoted-printable")
+	flag.BoolVar(&opts.WriteRaw, "raw", false, "also write the raw data of each MIME part, before decoding, to a "+mimeparse.RawSuffix+" file")
+	flag.BoolVar(&opts.LenientBase64, "lenient", false, "drop the invalid characters of the base64 parts rather than failing")
+	flag.BoolVar(&opts.FailFast, "failfast", false, "stop at the first MIME part which can't be extracted")
+	flag.IntVar(&opts.MaxParts, "maxparts", 0, "stop after extracting this many MIME parts (no limit if 0)")
+	flag.DurationVar(&opts.PartTimeout, "timeout", 0, "maximum time spent reading and decoding each MIME part, such as 10s (no limit if 0)")
+	flag.IntVar(&opts.Workers, "j", 0, "number of MIME parts decoded and written at the same time")
+	flag.BoolVar(&opts.PreserveDate, "t", false, "set the modification time of the files to the date of the message")
+	file_mode := flag.Uint("fmode", 0, "permissions of the files written, such as 0600 (default 0644)")
+	dir_mode := flag.Uint("dmode", 0, "permissions of the output directory, when created (default 0755)")
+	zip_name := flag.String("zip", "", "write the MIME parts to this zip archive rather than to separate files")
+	tar_name := flag.String("tar", "", "write the MIME parts to this tar archive, gzip compressed if it ends with .gz or .tgz, \"-\" for stdout")
+	flag.IntVar(&partIndex, "part", 0, "write the decoded data of the Nth MIME part, from 1, to stdout rather than extracting them all")
+	flag.StringVar(&partType, "part-type", "", "write the decoded data of the first MIME part of this media type, such as \"application/pdf\" or \"image/*\", to stdout (the Nth one with -part)")
+	flag.BoolVar(&verbose, "v", false, "display the tree of the MIME parts as JSON (not with -mbox)")
+	flag.BoolVar(&quiet, "q", false, "only display the errors")
+	flag.BoolVar(&mbox, "mbox", false, "read mailboxes in the mbox format, each message being written in a numbered subdirectory")
+	flag.Parse()
+
+	// The tree of the MIME parts is only built for a single message at a time
+	if verbose && mbox {
+		log.Println("-v can't be used with -mbox")
+		os.Exit(exitFailure)
+	}
+
+	opts.FileMode = os.FileMode(*file_mode)
+	opts.DirMode = os.FileMode(*dir_mode)
+	if *lf {
+		opts.NewLine = "\n"
+	}
+	if quiet {
+		opts.Verbosity = mimeparse.Quiet
+	}
+	if len(*include_types) > 0 {
+		opts.IncludeTypes = strings.Split(*include_types, ",")
+	}
+	if len(*exclude_types) > 0 {
+		opts.ExcludeTypes = strings.Split(*exclude_types, ",")
+	}
+	if len(*include_names) > 0 {
+		opts.IncludeNames = strings.Split(*include_names, ",")
+	}
+
+	// The table of the parts is all that is displayed
+	if list {
+		opts.DryRun = true
+	}
+
+	// The events are all that is written to stdout
+	if events {
+		opts.Events = os.Stdout
+		quiet = true
+	}
+
+	// The data of the part selected is written to stdout, and nothing else
+	if partIndex > 0 || len(partType) > 0 {
+		quiet = true
+	}
 
-// BuildFileName builds a file name for a MIME part, using information extracted from
-// the part itself, as well as a radix and an index given as parameters.
-func BuildFileName(part *multipart.Part, radix string, index int) (filename string) {
+	// With -zip or -tar, the parts of all the messages are written to a single archive
+	var archive_file *os.File
+	switch {
+	case len(*zip_name) > 0:
+		var err error
+		if archive_file, err = os.Create(*zip_name); err != nil {
+			log.Println(err)
+			os.Exit(exitFailure)
+		}
+		opts.Archive = mimeparse.NewZipArchive(archive_file)
+	case *tar_name == "-":
+		// The headers of the messages mustn't be mixed with the archive
+		archive_file = os.Stdout
+		quiet = true
+		opts.Archive = mimeparse.NewTarArchive(archive_file, false)
+	case len(*tar_name) > 0:
+		var err error
+		if archive_file, err = os.Create(*tar_name); err != nil {
+			log.Println(err)
+			os.Exit(exitFailure)
+		}
+		compress := strings.HasSuffix(*tar_name, ".gz") || strings.HasSuffix(*tar_name, ".tgz")
+		opts.Archive = mimeparse.NewTarArchive(archive_file, compress)
+	}
 
-	// 1st try to get the true file name if there is one in Content-Disposition
-	filename = part.FileName()
-	if len(filename) > 0 {
+	if flag.NArg() == 0 {
+		err := extract(os.Stdin, opts)
+		if e := closeArchive(opts.Archive, archive_file); err == nil {
+			err = e
+		}
+		if err != nil {
+			log.Println(err)
+			os.Exit(exitCode(err))
+		}
 		return
 	}
 
-	// If no defaut filename defined, try to build one of the following format :
-	// "radix-index.ext" where extension is comuputed from the Content-Type of the part
-	mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
-	if err == nil {
-		mime_type, e := mime.ExtensionsByType(mediaType)
-		if e == nil {
-			return fmt.Sprintf("%s-%d%s", radix, index, mime_type[0])
+	// The exit code is the one of the worst error met
+	code := exitSuccess
+	for _, filename := range flag.Args() {
+
+		file_opts := opts
+		if flag.NArg() > 1 {
+			name := strings.TrimSuffix(filepath.Base(filename), ".gz")
+			file_opts.OutputDir = filepath.Join(opts.OutputDir, strings.TrimSuffix(name, filepath.Ext(name)))
 		}
+
+		if err := extractFile(filename, file_opts); err != nil {
+			log.Println(err)
+			code = max(code, exitCode(err))
+		}
+
 	}
 
-	return
+	if err := closeArchive(opts.Archive, archive_file); err != nil {
+		log.Println(err)
+		code = max(code, exitCode(err))
+	}
+
+	os.Exit(code)
 
 }
 
-// WitePart decodes the data of MIME part and writes it to the file filename.
-func WritePart(part *multipart.Part, filename string) {
+// The exit codes of the command.
+const (
+	// exitSuccess is returned when all the MIME parts were extracted.
+	exitSuccess = 0
 
-	// Read the data for this MIME part
-	part_data, err := ioutil.ReadAll(part)
-	if err != nil {
-		log.Println("Error reading MIME part data -", err)
-		return
-	}
+	// exitPartial is returned when some MIME parts couldn't be extracted,
+	// all the others being extracted, or when the part selected with -part
+	// or -part-type doesn't exist.
+	exitPartial = 1
+
+	// exitFailure is returned when an email couldn't be read at all, or the
+	// files it is read from, or the archive written, couldn't be opened. It
+	// is also returned by the flag package for an invalid flag.
+	exitFailure = 2
+)
 
-	content_transfer_encoding := strings.ToUpper(part.Header.Get("Content-Transfer-Encoding"))
+// fatalError is an error preventing all the MIME parts of an email, or of a
+// mailbox, from being extracted, as opposed to the errors of some of them.
+type fatalError struct {
+	err error
+}
+
+func (e fatalError) Error() string {
+	return e.err.Error()
+}
+
+func (e fatalError) Unwrap() error {
+	return e.err
+}
 
+// exitCode returns the exit code for err, the error met while extracting the
+// MIME parts of the emails.
+func exitCode(err error) int {
+
+	var fatal fatalError
 	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.As(err, &fatal):
+		return exitFailure
+	}
 
-		case strings.Compare(content_transfer_encoding, "BASE64") == 0:
-			decoded_content, err := base64.StdEncoding.DecodeString(string(part_data))
-			if err != nil {
-				log.Println("Error decoding base64 -", err)
-			} else {
-				ioutil.WriteFile(filename, decoded_content, 0644)
-			}
+	return exitPartial
 
-		case strings.Compare(content_transfer_encoding, "QUOTED-PRINTABLE") == 0:
-			decoded_content, err := ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(part_data)))
-			if err != nil {
-				log.Println("Error decoding quoted-printable -", err)
-			} else {
-				ioutil.WriteFile(filename, decoded_content, 0644)
-			}
+}
+
+// max returns the greatest of a and b.
+func max(a, b int) int {
 
-		default:
-			ioutil.WriteFile(filename, part_data, 0644)
+	if a > b {
+		return a
+	}
 
-	}	
+	return b
 
 }
 
+// closeArchive completes the archive written to file, if any, even when some
+// of the messages couldn't be parsed, so the parts extracted can be used.
+func closeArchive(archive *mimeparse.Archive, file *os.File) error {
 
-// ParsePart parses the MIME part from mime_data, each part being separated by
-// boundary. If one of the part read is itself a multipart MIME part, the
-// function calls itself to recursively parse all the parts. The parts read
-// are decoded and written to separate files, named uppon their Content-Descrption
-// (or boundary if no Content-Description available) with the appropriate
-// file extension. Index is incremented at each recursive level and is used in
-// building the filename where the part is written, as to ensure all filenames
-// are distinct.
-func ParsePart(mime_data io.Reader, boundary string, index int) {
-
-	// Instantiate a new io.Reader dedicated to MIME multipart parsing
-	// using multipart.NewReader()
-	reader := multipart.NewReader(mime_data, boundary)
-	if reader == nil {
-		return
+	if archive == nil {
+		return nil
+	}
+
+	err := archive.Close()
+	if e := file.Close(); err == nil && e != nil {
+		err = e
+	}
+
+	if err != nil {
+		return fatalError{err}
+	}
+
+	return nil
+
+}
+
+// extractFile explodes the MIME parts of the email read from the file filename.
+func extractFile(filename string, opts mimeparse.Options) error {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return fatalError{err}
+	}
+	defer file.Close()
+
+	if err := extract(file, opts); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
 	}
 
-	fmt.Println(strings.Repeat("  ", 2*(index-1)), ">>>>>>>>>>>>> ", boundary)
+	return nil
+
+}
 
-	// Go through each of the MIME part of the message Body with NextPart(),
-	// and read the content of the MIME part with ioutil.ReadAll()
-	for {
+// extract explodes the MIME parts of the email read from r, or of all the
+// emails of the mailbox read from r with -mbox, displaying the main headers
+// of the messages.
+func extract(r io.Reader, opts mimeparse.Options) error {
 
-		new_part, err := reader.NextPart()
-		if err == io.EOF {
-			break
+	r, err := decompress(r)
+	if err != nil {
+		return fatalError{err}
+	}
+
+	if partIndex > 0 || len(partType) > 0 {
+		return extractSelected(r, opts)
+	}
+
+	if mbox {
+		messages, err := mimeparse.ParseMbox(r, opts)
+		for _, m := range messages {
+			display(m, opts)
 		}
-		if err != nil {
-			fmt.Println("Error going through the MIME parts -", err)
-			break
+		if err != nil && len(messages) == 0 && opts.Events == nil {
+			return fatalError{err}
 		}
+		return err
+	}
+
+	// The tree of the message is built as it is read to be extracted, the
+	// data read being handed to ParseEmail through a pipe
+	var pipe *io.PipeReader
+	var tree_done chan struct{}
+	if verbose {
+		var w *io.PipeWriter
+		pipe, w = io.Pipe()
+		tree_done = make(chan struct{})
+		tee := io.TeeReader(r, w)
+		go func() {
+			defer close(tree_done)
+			displayStructure(tee, opts)
+			// The rest of the message, past the end of its parts
+			_, err := io.Copy(io.Discard, tee)
+			w.CloseWithError(err)
+		}()
+		r = pipe
+	}
+
+	m, err := mimeparse.ParseEmail(r, opts)
+
+	// The tree is displayed once the whole message is read
+	if pipe != nil {
+		io.Copy(io.Discard, pipe)
+		<-tree_done
+	}
+
+	if m == nil {
+		return fatalError{fmt.Errorf("Parse mail KO - %w", err)}
+	}
+
+	display(m, opts)
 
-		for key, value := range new_part.Header {
-			fmt.Printf("%s Key: (%+v) - %d Value: (%#v)\n", strings.Repeat("  ", 2*(index-1)), key, len(value), value)
+	return err
+
+}
+
+// gzipMagic are the first bytes of gzip compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns a reader decompressing the data read from r if it is
+// gzip compressed, such as an .eml.gz file, or else a reader returning the
+// data of r as is.
+func decompress(r io.Reader) (io.Reader, error) {
+
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return buffered, nil
+	}
+
+	uncompressed, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing gzip data: %w", err)
+	}
+
+	return uncompressed, nil
+
+}
+
+// extractSelected writes the decoded data of the MIME part selected with
+// -part and -part-type, among the parts of the email read from r, to stdout.
+// An error is returned if there is no such part.
+func extractSelected(r io.Reader, opts mimeparse.Options) error {
+
+	// The parts are counted in the order they are read, which the workers
+	// of -j wouldn't keep, calling OnPart concurrently
+	opts.Workers = 0
+
+	found, n := false, 0
+	opts.OnPart = func(meta mimeparse.PartMeta, data io.Reader) error {
+
+		if found {
+			return nil
+		}
+		if len(partType) > 0 {
+			if matched, _ := path.Match(strings.ToLower(partType), meta.ContentType); !matched {
+				return nil
+			}
 		}
-		fmt.Println(strings.Repeat("  ", 2*(index-1)), "------------")
-
-		mediaType, params, err := mime.ParseMediaType(new_part.Header.Get("Content-Type"))
-		if err == nil && strings.HasPrefix(mediaType, "multipart/") {
-			ParsePart(new_part, params["boundary"], index+1)
-		} else {
-			filename := BuildFileName(new_part, boundary, 1)
-			WritePart(new_part, filename)
+		n++
+		if partIndex > 0 && n != partIndex {
+			return nil
 		}
 
+		found = true
+		_, err := io.Copy(os.Stdout, data)
+		return err
+
+	}
+
+	var err error
+	if mbox {
+		_, err = mimeparse.ParseMbox(r, opts)
+	} else if m, e := mimeparse.ParseEmail(r, opts); m == nil {
+		return fatalError{e}
+	} else {
+		err = e
+	}
+
+	if err == nil && !found {
+		err = errors.New("no such MIME part")
 	}
 
-	fmt.Println(strings.Repeat("  ", 2*(index-1)), "<<<<<<<<<<<<< ", boundary)
+	return err
 
 }
 
+// display displays the main headers of the message m, and its MIME parts in
+// a dry run.
+func display(m *mimeparse.Message, opts mimeparse.Options) {
 
-// Read a MIME multipart email from stdio and explode its MIME parts into
-// separated files, one for each part.
-func main() {
+	if quiet {
+		return
+	}
 
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if list {
+		listParts(os.Stdout, m)
+		return
+	}
 
-	//  Parse the message to separate the Header and the Body with mail.ReadMessage()
-	m, err := mail.ReadMessage(os.Stdin)
-	if err != nil {
-		log.Fatalln("Parse mail KO -", err)
-	}
-
-	// Display only the main headers of the message. The "From","To" and "Subject" headers
-	// have to be decoded if they were encoded using RFC 2047 to allow non ASCII characters.
-	// We use a mime.WordDecode for that.
-	dec := new(mime.WordDecoder)
-	from, _ := dec.DecodeHeader(m.Header.Get("From"))
-	to, _ := dec.DecodeHeader(m.Header.Get("To"))
-	subject, _ := dec.DecodeHeader(m.Header.Get("Subject"))
-	fmt.Println("From:", from)
-	fmt.Println("To:", to)
-	fmt.Println("Date:", m.Header.Get("Date"))
-	fmt.Println("Subject:", subject)
-	fmt.Println("Content-Type:", m.Header.Get("Content-Type"))
+	// Display only the main headers of the message
+	fmt.Println("From:", m.From)
+	fmt.Println("To:", m.To)
+	fmt.Println("Date:", m.Date)
+	fmt.Println("Subject:", m.Subject)
+	fmt.Println("Content-Type:", m.ContentType)
+	if m.Encrypted {
+		fmt.Println("The message is encrypted")
+	}
+	if opts.TempDir && len(m.Dir) > 0 {
+		fmt.Println("Directory:", m.Dir)
+	}
+	// Quoted, as they may hold anything
+	if len(m.Preamble) > 0 {
+		fmt.Printf("Preamble: %q%s\n", m.Preamble, truncatedNote(m.PreambleTruncated))
+	}
+	if len(m.Epilogue) > 0 {
+		fmt.Printf("Epilogue: %q%s\n", m.Epilogue, truncatedNote(m.EpilogueTruncated))
+	}
 	fmt.Println()
 
-	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
-	if err != nil {
-		log.Fatal(err)
+	if opts.DryRun {
+		for _, part := range m.Parts {
+			fmt.Printf("%s (%s)\n", part.Filename, part.ContentType)
+		}
+		fmt.Println()
 	}
 
-	if !strings.HasPrefix(mediaType, "multipart/") {
-		log.Fatalf("Not a multipart MIME message\n")
+}
+
+// truncatedNote returns the note displayed after a value which was truncated.
+func truncatedNote(truncated bool) string {
+
+	if truncated {
+		return " (truncated)"
 	}
 
-	// Recursivey parsed the MIME parts of the Body, starting with the first
-	// level where the MIME parts are separated with params["boundary"].
-	ParsePart(m.Body, params["boundary"], 1)
+	return ""
+
+}
+
+// displayStructure displays on stderr the tree of the MIME parts of the email
+// read from r, as JSON, along with the errors met while building it, within
+// the limits of opts.
+func displayStructure(r io.Reader, opts mimeparse.Options) {
+
+	tree, err := mimeparse.ParseStructure(r, opts)
+	if err != nil {
+		log.Println(err)
+	}
+	if tree == nil {
+		return
+	}
+
+	encoder := json.NewEncoder(os.Stderr)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(tree)
+
+}
+
+// listParts writes to out the table of the MIME parts of the message m, as
+// described by a dry run.
+func listParts(out io.Writer, m *mimeparse.Message) {
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INDEX\tPOSITION\tTYPE\tDISPOSITION\tFILENAME\tSIZE")
+	for _, part := range m.Parts {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\n", part.Index, part.Position, part.ContentType, part.Disposition, part.Filename, part.Written)
+	}
+	w.Flush()
+	fmt.Fprintln(out)
 
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kirabou/parseMIMEemail/mimeparse"
+)
+
+// The gzip compressed emails are decompressed, the others read as is.
+func TestDecompress(t *testing.T) {
+
+	email, err := os.ReadFile(filepath.Join("mimeparse", "testdata", "mixed.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write(email)
+	w.Close()
+
+	for _, data := range [][]byte{compressed.Bytes(), email} {
+
+		r, err := decompress(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		m, err := mimeparse.ParseEmail(r, mimeparse.Options{DryRun: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(m.Parts) != 2 || m.Parts[1].Filename != "report.csv" {
+			t.Errorf("got parts %v, want the ones of mixed.eml", m.Parts)
+		}
+
+	}
+
+	// A gzip header alone isn't enough
+	r, err := decompress(bytes.NewReader([]byte{0x1f, 0x8b}))
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if err == nil {
+		t.Error("got no error for truncated gzip data")
+	}
+
+}
+
+// The table of -list has a column for each field, aligned.
+func TestListParts(t *testing.T) {
+
+	email, err := os.Open(filepath.Join("mimeparse", "testdata", "nested.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer email.Close()
+	m, err := mimeparse.ParseEmail(email, mimeparse.Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var b bytes.Buffer
+	listParts(&b, m)
+
+	want := "INDEX  POSITION  TYPE            DISPOSITION  FILENAME      SIZE\n" +
+		"1      1.1       text/plain      inline       inner-1.txt   26\n" +
+		"2      1.2       text/html       inline       inner-2.html  33\n" +
+		"3      2         message/rfc822  attachment   outer-1.eml   109\n" +
+		"\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+
+}